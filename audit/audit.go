@@ -0,0 +1,86 @@
+package audit
+
+import (
+	"sort"
+	"time"
+
+	"github.com/zond/diplicity/common"
+	"github.com/zond/kcwraps/kol"
+)
+
+/*
+Entry records a privileged operation, like an admin rolling back a game.
+
+Error is empty if the operation succeeded.
+*/
+type Entry struct {
+	Id         kol.Id
+	Actor      string
+	Address    string
+	Action     string `kol:"index"`
+	Target     string `kol:"index"`
+	Parameters map[string]string
+	Error      string
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+type Entries []Entry
+
+func (self Entries) Len() int {
+	return len(self)
+}
+
+func (self Entries) Less(j, i int) bool {
+	return self[i].CreatedAt.Before(self[j].CreatedAt)
+}
+
+func (self Entries) Swap(i, j int) {
+	self[i], self[j] = self[j], self[i]
+}
+
+/*
+Record stores that actor performed action on target with parameters, and how it went.
+
+Failing to store the entry is logged, but doesn't fail the operation.
+*/
+func Record(c *common.HTTPContext, actor, action, target string, parameters map[string]string, result error) {
+	entry := &Entry{
+		Actor:      actor,
+		Address:    c.Req().RemoteAddr,
+		Action:     action,
+		Target:     target,
+		Parameters: parameters,
+	}
+	if result != nil {
+		entry.Error = result.Error()
+	}
+	c.Infof("%v at %v performed %v on %#v with %+v: %v", entry.Actor, entry.Address, entry.Action, entry.Target, entry.Parameters, result)
+	if err := c.DB().Set(entry); err != nil {
+		c.Errorf("Failed storing audit entry %+v: %v", entry, err)
+	}
+}
+
+/*
+AdminGetEntries renders the audit log, newest first, optionally filtered by the action and target form values.
+*/
+func AdminGetEntries(c *common.HTTPContext) (err error) {
+	filters := kol.And{}
+	for key, field := range map[string]string{"action": "Action", "target": "Target"} {
+		if value := c.Req().FormValue(key); value != "" {
+			filters = append(filters, kol.Equals{field, value})
+		}
+	}
+	entries := Entries{}
+	if len(filters) == 0 {
+		err = c.DB().Query().All(&entries)
+	} else {
+		err = c.DB().Query().Where(filters).All(&entries)
+	}
+	if err != nil {
+		return
+	}
+	sort.Sort(entries)
+	return c.RenderJSON(entries)
+}
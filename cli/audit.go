@@ -0,0 +1,39 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+
+	"github.com/zond/diplicity/audit"
+)
+
+/*
+printAudit prints the audit log of privileged operations, optionally only those with action and target.
+*/
+func (self *cli) printAudit(action, target string) (err error) {
+	query := url.Values{}
+	if action != "" {
+		query.Set("action", action)
+	}
+	if target != "" {
+		query.Set("target", target)
+	}
+	bod, err := self.get(fmt.Sprintf("/admin/audit?%v", query.Encode()))
+	if err != nil {
+		return
+	}
+	defer bod.Close()
+	entries := audit.Entries{}
+	if err = json.NewDecoder(bod).Decode(&entries); err != nil {
+		return
+	}
+	for _, entry := range entries {
+		result := "OK"
+		if entry.Error != "" {
+			result = entry.Error
+		}
+		fmt.Printf("%v\t%v (%v)\t%v\t%v\t%v\t%v\n", entry.CreatedAt.Format("2006-01-02 15:04:05"), entry.Actor, entry.Address, entry.Action, entry.Target, entry.Parameters, result)
+	}
+	return
+}
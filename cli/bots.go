@@ -0,0 +1,319 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/zond/diplicity/client"
+	"github.com/zond/diplicity/common"
+	"github.com/zond/diplicity/game"
+	"github.com/zond/kcwraps/kol"
+	"github.com/zond/wsubs/gosubs"
+)
+
+/*
+gameStats collects what happens while players play a game.
+*/
+type gameStats struct {
+	lock sync.Mutex
+	// The slowest commit of each phase, which is the one resolving it
+	latencies map[string]time.Duration
+	errors    []error
+	// Random orders often conflict, like too many builds, so the server refusing them is expected
+	rejections int
+}
+
+func (self *gameStats) commit(phaseId string, latency time.Duration) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if latency > self.latencies[phaseId] {
+		self.latencies[phaseId] = latency
+	}
+}
+
+func (self *gameStats) fail(err error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	fmt.Fprintln(os.Stderr, err)
+	self.errors = append(self.errors, err)
+}
+
+func (self *gameStats) failures() []error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return append([]error{}, self.errors...)
+}
+
+func (self *gameStats) reject() {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.rejections++
+}
+
+func (self *gameStats) report(state *client.GameState) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if state.State == int64(common.GameStateEnded) {
+		fmt.Printf("%v ended after %v phases due to %v\n", state.Id, state.Phases, state.EndReason)
+	} else {
+		fmt.Printf("%v didn't end in %v phases\n", state.Id, state.Phases)
+	}
+	if len(self.latencies) > 0 {
+		var min, max, sum time.Duration
+		for _, latency := range self.latencies {
+			if min == 0 || latency < min {
+				min = latency
+			}
+			if latency > max {
+				max = latency
+			}
+			sum += latency
+		}
+		fmt.Printf("Resolution latency over %v phases: min %v, avg %v, max %v\n", len(self.latencies), min, sum/time.Duration(len(self.latencies)), max)
+	}
+	fmt.Printf("%v orders rejected\n", self.rejections)
+	fmt.Printf("%v errors\n", len(self.errors))
+}
+
+func (self *cli) createBot(email string) (err error) {
+	_, err = self.post("/admin/users", map[string]interface{}{
+		"Email":                email,
+		"Id":                   kol.Id(email),
+		"DiplicityHost":        fmt.Sprintf("%v:%v", self.host, self.port),
+		"Bot":                  true,
+		"PhaseEmailDisabled":   true,
+		"MessageEmailDisabled": true,
+	})
+	return
+}
+
+/*
+createGame creates a classical game owned by c, with bots filling the seats nobody joins, and returns its id.
+*/
+func (self *cli) createGame(c *client.Client) (result string, err error) {
+	existing := map[string]bool{}
+	created := make(chan string, 1)
+	if err = c.SubscribeGamesMine(func(op string, states []client.GameState) error {
+		for _, state := range states {
+			if op == gosubs.FetchType {
+				existing[state.Id] = true
+			} else if !existing[state.Id] && state.State == int64(common.GameStateCreated) {
+				existing[state.Id] = true
+				created <- state.Id
+			}
+		}
+		return nil
+	}); err != nil {
+		return
+	}
+	deadlines := map[string]int64{}
+	for _, phaseType := range []string{"Movement", "Retreat", "Adjustment"} {
+		deadlines[phaseType] = 60
+	}
+	if err = c.CreateGames(&client.GameState{
+		Variant:          common.ClassicalString,
+		AllocationMethod: common.RandomString,
+		BotFill:          game.BotRandom,
+		Deadlines:        deadlines,
+		Members:          []client.MemberState{client.MemberState{}},
+	}); err != nil {
+		return
+	}
+	result = <-created
+	err = c.Unsubscribe("/games/mine")
+	return
+}
+
+/*
+playRandom gives a random order to each unit in phase, and commits.
+*/
+func playRandom(c *client.Client, phase client.BotPhase, stats *gameStats) (err error) {
+	opts, err := client.DecodeOptions(phase.Options)
+	if err != nil {
+		return
+	}
+	for _, provOrders := range opts.Orders() {
+		if err := c.SetOrder(&client.OrderRequest{
+			GameId: phase.GameId,
+			Order:  provOrders[rand.Intn(len(provOrders))],
+		}); err != nil {
+			stats.reject()
+		}
+	}
+	start := time.Now()
+	if err = c.Commit(&client.CommitRequest{
+		PhaseId: phase.Phase.Id,
+	}); err != nil {
+		return
+	}
+	stats.commit(phase.Phase.Id, time.Now().Sub(start))
+	return
+}
+
+/*
+playBot plays all phases email gets orders to give in with random orders.
+*/
+func (self *cli) playBot(email string, stats *gameStats) (c *client.Client, err error) {
+	if c, err = self.dial(email); err != nil {
+		return
+	}
+	c.ErrorHandler = func(err error) {
+		stats.fail(fmt.Errorf("%v: %v", email, err))
+	}
+	played := map[string]bool{}
+	err = c.SubscribeBotPhases(func(op string, phases []client.BotPhase) error {
+		for _, phase := range phases {
+			if phase.Phase == nil || played[phase.Phase.Id] {
+				continue
+			}
+			played[phase.Phase.Id] = true
+			// RPCs can't be called from the handler, since it runs in the goroutine receiving the responses
+			go func(phase client.BotPhase) {
+				if err := playRandom(c, phase, stats); err != nil {
+					stats.fail(fmt.Errorf("%v: %v", email, err))
+				}
+			}(phase)
+		}
+		return nil
+	})
+	return
+}
+
+/*
+runGame creates a game where the users with emails play random orders, with built-in bots in the remaining seats,
+and returns when it has ended, or with an error when it has lasted maxPhases phases or a phase has lasted phaseTimeout.
+*/
+func (self *cli) runGame(emails []string, maxPhases int, phaseTimeout time.Duration) (result *client.GameState, stats *gameStats, err error) {
+	nations := len(common.VariantMap[common.ClassicalString].Nations)
+	if len(emails) < 1 || len(emails) > nations {
+		err = fmt.Errorf("Can't run a game with %v players, it has %v nations", len(emails), nations)
+		return
+	}
+	owner, err := self.dial(emails[0])
+	if err != nil {
+		return
+	}
+	defer owner.Close()
+	gameId, err := self.createGame(owner)
+	if err != nil {
+		return
+	}
+	fmt.Printf("Created %v\n", gameId)
+	stats = &gameStats{
+		latencies: map[string]time.Duration{},
+	}
+	for _, email := range emails {
+		var c *client.Client
+		if c, err = self.playBot(email, stats); err != nil {
+			return
+		}
+		defer c.Close()
+	}
+	ended := make(chan *client.GameState, 1)
+	progressed := make(chan *client.GameState, 1)
+	once := &sync.Once{}
+	phases := int64(-1)
+	if err = owner.SubscribeGame(gameId, func(op string, state *client.GameState) error {
+		if state == nil {
+			return nil
+		}
+		if state.Phases != phases {
+			phases = state.Phases
+			// Only the latest progress matters
+			select {
+			case <-progressed:
+			default:
+			}
+			progressed <- state
+		}
+		if state.State == int64(common.GameStateEnded) || (maxPhases > 0 && state.Phases >= int64(maxPhases)) {
+			once.Do(func() {
+				ended <- state
+			})
+		}
+		return nil
+	}); err != nil {
+		return
+	}
+	decodedId, err := kol.DecodeId(gameId)
+	if err != nil {
+		return
+	}
+	for _, email := range emails[1:] {
+		if err = self.join(email, decodedId); err != nil {
+			return
+		}
+	}
+	if len(emails) < nations {
+		if err = owner.StartWithBots(&client.StartRequest{
+			GameId: gameId,
+		}); err != nil {
+			return
+		}
+	}
+	var latest *client.GameState
+	for result == nil {
+		select {
+		case result = <-ended:
+		case latest = <-progressed:
+		case <-time.After(phaseTimeout):
+			result = latest
+			failures := stats.failures()
+			err = fmt.Errorf("%v didn't get a new phase in %v, %v errors: %v", gameId, phaseTimeout, len(failures), failures)
+			return
+		}
+	}
+	if result.State != int64(common.GameStateEnded) {
+		err = fmt.Errorf("%v didn't end in %v phases", gameId, maxPhases)
+	}
+	return
+}
+
+/*
+botGame runs a game of n bot accounts using the bot protocol against the server.
+*/
+func (self *cli) botGame(n, maxPhases int, phaseTimeout time.Duration) (err error) {
+	emails := []string{}
+	for i := 0; i < n; i++ {
+		email := fmt.Sprintf("bot%v@bots.tld", i)
+		if err = self.createBot(email); err != nil {
+			return
+		}
+		emails = append(emails, email)
+	}
+	state, stats, err := self.runGame(emails, maxPhases, phaseTimeout)
+	if state != nil {
+		stats.report(state)
+	}
+	return
+}
+
+/*
+simulate plays a game of n fake users with random orders to completion, and reports how it went.
+*/
+func (self *cli) simulate(n, maxPhases int, phaseTimeout time.Duration) (err error) {
+	emails := []string{}
+	for i := 0; i < n; i++ {
+		email := fmt.Sprintf("%v@dom.tld", i+1)
+		if err = self.createUser(email); err != nil {
+			return
+		}
+		emails = append(emails, email)
+	}
+	start := time.Now()
+	state, stats, err := self.runGame(emails, maxPhases, phaseTimeout)
+	if state != nil {
+		stats.report(state)
+		fmt.Printf("Played in %v\n", time.Now().Sub(start))
+	}
+	if err != nil {
+		return
+	}
+	if len(stats.errors) > 0 {
+		err = fmt.Errorf("%v errors while simulating %v", len(stats.errors), state.Id)
+	}
+	return
+}
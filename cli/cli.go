@@ -7,12 +7,12 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
-	"math/rand"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
-	"code.google.com/p/go.net/websocket"
+	"github.com/zond/diplicity/client"
 	"github.com/zond/diplicity/common"
 	"github.com/zond/diplicity/game"
 	"github.com/zond/kcwraps/kol"
@@ -37,37 +37,12 @@ func (self *cli) token(email string) (result string, err error) {
 	return
 }
 
-func (self *cli) connect(email string) (ws *websocket.Conn, receiver chan gosubs.Message, err error) {
+func (self *cli) dial(email string) (result *client.Client, err error) {
 	token, err := self.token(email)
 	if err != nil {
 		return
 	}
-	if ws, err = websocket.Dial(fmt.Sprintf("ws://%v:%v/ws?token=%v", self.host, self.port, token), "tcp", "http://localhost/"); err != nil {
-		return
-	}
-	receiver = make(chan gosubs.Message, 1024)
-	go func() {
-		var err error
-		for err == nil {
-			mess := gosubs.Message{}
-			if err = websocket.JSON.Receive(ws, &mess); err == nil {
-				receiver <- mess
-			}
-		}
-	}()
-	return
-}
-
-func (self *cli) send(email string, mess gosubs.Message) (err error) {
-	ws, _, err := self.connect(email)
-	if err != nil {
-		return
-	}
-	defer ws.Close()
-	if err = websocket.JSON.Send(ws, mess); err != nil {
-		return
-	}
-	return
+	return client.Dial(fmt.Sprintf("ws://%v:%v/ws", self.host, self.port), token)
 }
 
 func (self *cli) post(path string, obj interface{}) (response string, err error) {
@@ -92,7 +67,11 @@ func (self *cli) post(path string, obj interface{}) (response string, err error)
 func (self *cli) get(path string) (result io.ReadCloser, err error) {
 	token, err := self.token(common.Admin)
 	cli := &http.Client{}
-	resp, err := cli.Get(fmt.Sprintf("http://%v:%v%v?token=%v", self.host, self.port, path, token))
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+	resp, err := cli.Get(fmt.Sprintf("http://%v:%v%v%vtoken=%v", self.host, self.port, path, separator, token))
 	if err != nil {
 		panic(err)
 	}
@@ -115,35 +94,35 @@ func (self *cli) game(id string) (result game.AdminGameState, err error) {
 	return
 }
 
-func (self *cli) rpc(email string, method string, data interface{}) (result interface{}, err error) {
-	ws, rec, err := self.connect(email)
+func (self *cli) join(email string, gameId kol.Id) (err error) {
+	c, err := self.dial(email)
 	if err != nil {
 		return
 	}
-	defer ws.Close()
-	id := fmt.Sprint(rand.Int63())
-	if err = websocket.JSON.Send(ws, gosubs.Message{
-		Type: gosubs.RPCType,
-		Method: &gosubs.Method{
-			Name: "Commit",
-			Id:   id,
-			Data: data,
-		},
-	}); err != nil {
-		return
-	}
-	var mess gosubs.Message
-	for mess = <-rec; mess.Type != gosubs.RPCType || mess.Method.Id != id; mess = <-rec {
+	defer c.Close()
+	preferredNations := []string{}
+	for _, nation := range common.VariantMap[common.ClassicalString].Nations {
+		preferredNations = append(preferredNations, string(nation))
 	}
-	result = mess.Method.Data
-	return
+	return c.UpdateGame(gameId.String(), &client.GameState{
+		Id: gameId.String(),
+		Members: []client.MemberState{
+			client.MemberState{
+				PreferredNations: preferredNations,
+			},
+		},
+	})
 }
 
-func (self *cli) commit(email string, phaseId interface{}) (err error) {
-	_, err = self.rpc(email, "Commit", map[string]interface{}{
-		"PhaseId": phaseId,
+func (self *cli) commit(email string, phaseId kol.Id) (err error) {
+	c, err := self.dial(email)
+	if err != nil {
+		return
+	}
+	defer c.Close()
+	return c.Commit(&client.CommitRequest{
+		PhaseId: phaseId.String(),
 	})
-	return
 }
 
 func main() {
@@ -159,8 +138,17 @@ func main() {
 	rollback := flag.String("rollback", "", "A game to rollback.")
 	recalc := flag.String("recalc", "", "A game to recalculate options for.")
 	until := flag.Int("until", 100000, "A phase ordinal to roll back to. This will be the unresolved phase.")
+	reason := flag.String("reason", "", "Why the game defined by -rollback is rolled back.")
+	restore := flag.String("restore", "", "A rollback to undo, restoring the phases it archived. Rollbacks of a game are listed at /admin/games/{game_id}/rollbacks.")
 	reindex := flag.Bool("reindex", false, "Reindex all games in the database.")
 	setrank1 := flag.Bool("setrank1", false, "Set rank of all users to 1.")
+	simulate := flag.Int("simulate", 0, "A number of fake users to play a new game with random orders until it ends, reporting phases, resolution latency and errors.")
+	showAudit := flag.Bool("audit", false, "Print the audit log of privileged operations, newest first.")
+	auditAction := flag.String("audit_action", "", "Only print audit log entries with this action, like Rollback.")
+	auditTarget := flag.String("audit_target", "", "Only print audit log entries with this target, like a game id.")
+	maxPhases := flag.Int("max_phases", 500, "The number of phases after which -simulate and -bots give up on the game ending, and fail. 0 allows any number of phases.")
+	phaseTimeout := flag.Duration("phase_timeout", 5*time.Minute, "How long -simulate and -bots wait for a new phase before giving up on the game, and fail.")
+	bots := flag.Int("bots", 0, "A number of bot accounts to play a new game with random orders until it ends, with built-in bots in the remaining seats.")
 
 	flag.Parse()
 
@@ -177,11 +165,29 @@ func main() {
 		}
 		io.Copy(os.Stdout, bod)
 	} else {
-		if *join == "" && *commitAll == "" && *commit == "" && *rollback == "" && *recalc == "" && *reindex == false && *setrank1 == false {
+		if *join == "" && *commitAll == "" && *commit == "" && *rollback == "" && *restore == "" && *recalc == "" && *reindex == false && *setrank1 == false && *bots == 0 && *simulate == 0 && !*showAudit {
 			flag.Usage()
 			return
 		}
 
+		if *showAudit {
+			if err := cli.printAudit(*auditAction, *auditTarget); err != nil {
+				panic(err)
+			}
+		}
+
+		if *simulate > 0 {
+			if err := cli.simulate(*simulate, *maxPhases, *phaseTimeout); err != nil {
+				panic(err)
+			}
+		}
+
+		if *bots > 0 {
+			if err := cli.botGame(*bots, *maxPhases, *phaseTimeout); err != nil {
+				panic(err)
+			}
+		}
+
 		if *reindex {
 			if resp, err := cli.post("/admin/games/reindex", nil); err != nil {
 				panic(err)
@@ -205,7 +211,15 @@ func main() {
 		}
 
 		if *rollback != "" {
-			if _, err := cli.post(fmt.Sprintf("/admin/games/%v/rollback/%v", *rollback, *until), map[string]interface{}{}); err != nil {
+			if _, err := cli.post(fmt.Sprintf("/admin/games/%v/rollback/%v", *rollback, *until), map[string]interface{}{
+				"Reason": *reason,
+			}); err != nil {
+				panic(err)
+			}
+		}
+
+		if *restore != "" {
+			if _, err := cli.post(fmt.Sprintf("/admin/rollbacks/%v/restore", *restore), nil); err != nil {
 				panic(err)
 			}
 		}
@@ -230,24 +244,7 @@ func main() {
 				if err := cli.createUser(*email); err != nil {
 					panic(err)
 				}
-				if err := cli.send(*email, gosubs.Message{
-					Type: gosubs.UpdateType,
-					Object: &gosubs.Object{
-						URI: fmt.Sprintf("/games/%v", *join),
-						Data: game.GameState{
-							Game: &game.Game{
-								Id: g.Game.Id,
-							},
-							Members: []game.MemberState{
-								game.MemberState{
-									Member: &game.Member{
-										PreferredNations: common.VariantMap[common.ClassicalString].Nations,
-									},
-								},
-							},
-						},
-					},
-				}); err != nil {
+				if err := cli.join(*email, g.Game.Id); err != nil {
 					panic(err)
 				}
 				*email = fmt.Sprintf("%v@dom.tld", *joinX-1)
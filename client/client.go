@@ -0,0 +1,223 @@
+/*
+Package client talks to diplicity servers over the WebSocket protocol.
+
+The typed resources and RPCs in generated.go are generated from the schema served by the server at /api/v1/schema.
+*/
+package client
+
+//go:generate go run generate/generate.go -schema http://localhost:8080/api/v1/schema -out generated.go
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"sync"
+
+	"code.google.com/p/go.net/websocket"
+	"github.com/zond/wsubs/gosubs"
+)
+
+type object struct {
+	URI  string
+	Data json.RawMessage
+}
+
+type method struct {
+	Name string
+	Id   string
+	Data json.RawMessage
+}
+
+type failure struct {
+	Cause *message
+	Error string
+}
+
+/*
+message is a gosubs.Message with the data left undecoded until we know what it is.
+*/
+type message struct {
+	Type   string
+	Object *object
+	Method *method
+	Error  *failure
+}
+
+/*
+Client is a connection to a diplicity server.
+
+Errors the server reports for anything but RPCs are passed to ErrorHandler, if set.
+*/
+type Client struct {
+	ErrorHandler func(err error)
+
+	ws            *websocket.Conn
+	lock          sync.Mutex
+	nextId        int64
+	calls         map[string]chan *message
+	subscriptions map[string]func(op string, data json.RawMessage) error
+	closed        error
+}
+
+/*
+Dial connects to the WebSocket at addr, like ws://localhost:8080/ws, authenticated with token unless it is empty.
+
+The token can be an encoded gosubs.Token or a personal API token.
+*/
+func Dial(addr, token string) (result *Client, err error) {
+	if token != "" {
+		addr = fmt.Sprintf("%v?token=%v", addr, url.QueryEscape(token))
+	}
+	result = &Client{
+		calls:         map[string]chan *message{},
+		subscriptions: map[string]func(op string, data json.RawMessage) error{},
+	}
+	if result.ws, err = websocket.Dial(addr, "", "http://localhost/"); err != nil {
+		return
+	}
+	go result.receive()
+	return
+}
+
+func (self *Client) Close() error {
+	return self.ws.Close()
+}
+
+func (self *Client) handleError(err error) {
+	if self.ErrorHandler != nil {
+		self.ErrorHandler(err)
+	}
+}
+
+func (self *Client) receive() {
+	for {
+		mess := &message{}
+		if err := websocket.JSON.Receive(self.ws, mess); err != nil {
+			self.lock.Lock()
+			self.closed = err
+			for id, call := range self.calls {
+				close(call)
+				delete(self.calls, id)
+			}
+			self.lock.Unlock()
+			return
+		}
+		switch mess.Type {
+		case gosubs.RPCType:
+			if mess.Method != nil {
+				self.deliver(mess.Method.Id, mess)
+			}
+		case gosubs.ErrorType:
+			if mess.Error == nil {
+				continue
+			}
+			if cause := mess.Error.Cause; cause != nil && cause.Type == gosubs.RPCType && cause.Method != nil {
+				self.deliver(cause.Method.Id, mess)
+			} else {
+				self.handleError(fmt.Errorf("%v", mess.Error.Error))
+			}
+		default:
+			if mess.Object == nil {
+				continue
+			}
+			self.lock.Lock()
+			handler, found := self.subscriptions[mess.Object.URI]
+			self.lock.Unlock()
+			if found {
+				if err := handler(mess.Type, mess.Object.Data); err != nil {
+					self.handleError(err)
+				}
+			}
+		}
+	}
+}
+
+func (self *Client) deliver(id string, mess *message) {
+	self.lock.Lock()
+	call, found := self.calls[id]
+	delete(self.calls, id)
+	self.lock.Unlock()
+	if found {
+		call <- mess
+	}
+}
+
+func (self *Client) send(mess gosubs.Message) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.closed != nil {
+		return self.closed
+	}
+	return websocket.JSON.Send(self.ws, mess)
+}
+
+/*
+Call calls the RPC name with data, and decodes the response into result unless it is nil.
+*/
+func (self *Client) Call(name string, data, result interface{}) (err error) {
+	call := make(chan *message, 1)
+	self.lock.Lock()
+	self.nextId++
+	id := strconv.FormatInt(self.nextId, 10)
+	self.calls[id] = call
+	self.lock.Unlock()
+	if err = self.send(gosubs.Message{
+		Type: gosubs.RPCType,
+		Method: &gosubs.Method{
+			Name: name,
+			Id:   id,
+			Data: data,
+		},
+	}); err != nil {
+		self.lock.Lock()
+		delete(self.calls, id)
+		self.lock.Unlock()
+		return
+	}
+	mess, ok := <-call
+	if !ok {
+		err = fmt.Errorf("Connection closed while calling %v", name)
+		return
+	}
+	if mess.Error != nil {
+		err = fmt.Errorf("%v: %v", name, mess.Error.Error)
+		return
+	}
+	if result != nil && len(mess.Method.Data) > 0 {
+		err = json.Unmarshal(mess.Method.Data, result)
+	}
+	return
+}
+
+/*
+Subscribe subscribes to uri, and calls handler with the operation and data of everything the server sends about it.
+*/
+func (self *Client) Subscribe(uri string, handler func(op string, data json.RawMessage) error) (err error) {
+	self.lock.Lock()
+	self.subscriptions[uri] = handler
+	self.lock.Unlock()
+	return self.Send(gosubs.SubscribeType, uri, nil)
+}
+
+func (self *Client) Unsubscribe(uri string) (err error) {
+	self.lock.Lock()
+	delete(self.subscriptions, uri)
+	self.lock.Unlock()
+	return self.Send(gosubs.UnsubscribeType, uri, nil)
+}
+
+/*
+Send sends op, like gosubs.CreateType, with data to uri.
+
+The server only answers if it fails, and then to the ErrorHandler.
+*/
+func (self *Client) Send(op, uri string, data interface{}) error {
+	return self.send(gosubs.Message{
+		Type: op,
+		Object: &gosubs.Object{
+			URI:  uri,
+			Data: data,
+		},
+	})
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go/format"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/zond/diplicity/common"
+	"github.com/zond/wsubs/gosubs"
+)
+
+var groupReg = regexp.MustCompile("\\([^)]*\\)")
+
+var opPrefixes = map[string]string{
+	gosubs.SubscribeType: "Subscribe",
+	gosubs.CreateType:    "Create",
+	gosubs.UpdateType:    "Update",
+	gosubs.DeleteType:    "Delete",
+}
+
+type generator struct {
+	schema *common.APISchema
+	buf    *bytes.Buffer
+}
+
+func (self *generator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(self.buf, format, args...)
+}
+
+func lowerFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToLower(s[:1]) + s[1:]
+}
+
+/*
+goType returns the Go type of values matching s, with definitions as pointers if pointer.
+*/
+func (self *generator) goType(s *common.JSONSchema, pointer bool) string {
+	if s.Ref != "" {
+		name := strings.TrimPrefix(s.Ref, "#/definitions/")
+		if pointer {
+			return "*" + name
+		}
+		return name
+	}
+	switch s.Type {
+	case "boolean":
+		return "bool"
+	case "integer":
+		return "int64"
+	case "number":
+		return "float64"
+	case "string":
+		if s.Format == "date-time" {
+			return "time.Time"
+		}
+		return "string"
+	case "array":
+		return "[]" + self.goType(s.Items, false)
+	case "object":
+		if s.AdditionalProperties != nil {
+			return "map[string]" + self.goType(s.AdditionalProperties, false)
+		}
+		return fmt.Sprintf("struct {\n%v}", self.fields(s))
+	}
+	return "interface{}"
+}
+
+func (self *generator) fields(s *common.JSONSchema) string {
+	names := []string{}
+	for name, _ := range s.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	buf := &bytes.Buffer{}
+	for _, name := range names {
+		fmt.Fprintf(buf, "%v %v `json:\"%v,omitempty\"`\n", name, self.goType(s.Properties[name], true), name)
+	}
+	return buf.String()
+}
+
+func (self *generator) definitions() {
+	names := []string{}
+	for name, _ := range self.schema.Definitions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		self.printf("type %v struct {\n%v}\n\n", name, self.fields(self.schema.Definitions[name]))
+	}
+}
+
+func (self *generator) resources() {
+	for _, resource := range self.schema.Resources {
+		if resource.Name == "" {
+			continue
+		}
+		params := []string{}
+		args := []string{}
+		for _, param := range resource.Params {
+			params = append(params, fmt.Sprintf("%v string", lowerFirst(param)))
+			args = append(args, lowerFirst(param))
+		}
+		uri := fmt.Sprintf("%#v", groupReg.ReplaceAllString(strings.TrimSuffix(strings.TrimPrefix(resource.URI, "^"), "$"), "%v"))
+		if len(args) > 0 {
+			uri = fmt.Sprintf("fmt.Sprintf(%v, %v)", uri, strings.Join(args, ", "))
+		}
+		for _, op := range resource.Operations {
+			prefix, found := opPrefixes[op.Type]
+			if !found {
+				continue
+			}
+			funcName := prefix + resource.Name
+			if op.Type == gosubs.SubscribeType {
+				self.printf("// %v subscribes to %v.\n", funcName, resource.URI)
+				payloadType := "json.RawMessage"
+				if op.Payload != nil {
+					payloadType = self.goType(op.Payload, true)
+				}
+				self.printf("func (self *Client) %v(%v) error {\n", funcName, strings.Join(append(params, fmt.Sprintf("handler func(op string, payload %v) error", payloadType)), ", "))
+				self.printf("return self.Subscribe(%v, func(op string, data json.RawMessage) (err error) {\n", uri)
+				if op.Payload != nil {
+					self.printf("var payload %v\n", payloadType)
+					self.printf("if len(data) > 0 {\nif err = json.Unmarshal(data, &payload); err != nil {\nreturn\n}\n}\n")
+					self.printf("return handler(op, payload)\n")
+				} else {
+					self.printf("return handler(op, data)\n")
+				}
+				self.printf("})\n}\n\n")
+			} else {
+				self.printf("// %v sends %v to %v.\n", funcName, op.Type, resource.URI)
+				payload := "nil"
+				if op.Payload != nil {
+					params = append(params, fmt.Sprintf("payload %v", self.goType(op.Payload, true)))
+					payload = "payload"
+				}
+				self.printf("func (self *Client) %v(%v) error {\n", funcName, strings.Join(params, ", "))
+				self.printf("return self.Send(%#v, %v, %v)\n}\n\n", op.Type, uri, payload)
+				if op.Payload != nil {
+					params = params[:len(params)-1]
+				}
+			}
+		}
+	}
+}
+
+func (self *generator) rpcs() {
+	for _, rpc := range self.schema.RPCs {
+		params := ""
+		data := "nil"
+		if rpc.Request != nil {
+			params = fmt.Sprintf("req %v", self.goType(rpc.Request, true))
+			data = "req"
+		}
+		self.printf("// %v calls the %v RPC.\n", rpc.Name, rpc.Name)
+		if rpc.Response != nil {
+			self.printf("func (self *Client) %v(%v) (result %v, err error) {\n", rpc.Name, params, self.goType(rpc.Response, true))
+			self.printf("err = self.Call(%#v, %v, &result)\nreturn\n}\n\n", rpc.Name, data)
+		} else {
+			self.printf("func (self *Client) %v(%v) error {\n", rpc.Name, params)
+			self.printf("return self.Call(%#v, %v, nil)\n}\n\n", rpc.Name, data)
+		}
+	}
+}
+
+func (self *generator) generate() (result []byte, err error) {
+	self.definitions()
+	self.resources()
+	self.rpcs()
+	body := self.buf.String()
+	self.buf = &bytes.Buffer{}
+	self.printf("// generated by generate/generate.go from the API schema, DO NOT EDIT\n\n")
+	self.printf("package client\n\nimport (\n")
+	for _, pkg := range []string{"encoding/json", "fmt", "time"} {
+		if strings.Contains(body, pkg[strings.LastIndex(pkg, "/")+1:]+".") {
+			self.printf("%#v\n", pkg)
+		}
+	}
+	self.printf(")\n\n%v", body)
+	return format.Source(self.buf.Bytes())
+}
+
+func open(source string) (result io.ReadCloser, err error) {
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		var resp *http.Response
+		if resp, err = http.Get(source); err != nil {
+			return
+		}
+		if resp.StatusCode != 200 {
+			resp.Body.Close()
+			err = fmt.Errorf("%v returned %v", source, resp.Status)
+			return
+		}
+		result = resp.Body
+		return
+	}
+	return os.Open(source)
+}
+
+func main() {
+	source := flag.String("schema", "http://localhost:8080/api/v1/schema", "The URL or file of the API schema.")
+	out := flag.String("out", "generated.go", "The file to write the client to.")
+
+	flag.Parse()
+
+	in, err := open(*source)
+	if err != nil {
+		panic(err)
+	}
+	defer in.Close()
+	gen := &generator{
+		schema: &common.APISchema{},
+		buf:    &bytes.Buffer{},
+	}
+	if err = json.NewDecoder(in).Decode(gen.schema); err != nil {
+		panic(err)
+	}
+	b, err := gen.generate()
+	if err != nil {
+		panic(fmt.Errorf("%v\n%s", err, gen.buf.Bytes()))
+	}
+	if err = ioutil.WriteFile(*out, b, 0644); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,557 @@
+// generated by generate/generate.go from the API schema, DO NOT EDIT
+
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+type APIToken struct {
+	CreatedAt time.Time `json:"CreatedAt,omitempty"`
+	Digest    string    `json:"Digest,omitempty"`
+	Id        string    `json:"Id,omitempty"`
+	Name      string    `json:"Name,omitempty"`
+	Scopes    []string  `json:"Scopes,omitempty"`
+	UpdatedAt time.Time `json:"UpdatedAt,omitempty"`
+	UserId    string    `json:"UserId,omitempty"`
+}
+
+type APITokenRequest struct {
+	Id     string   `json:"Id,omitempty"`
+	Name   string   `json:"Name,omitempty"`
+	Scopes []string `json:"Scopes,omitempty"`
+}
+
+type BotPhase struct {
+	GameId   string      `json:"GameId,omitempty"`
+	MemberId string      `json:"MemberId,omitempty"`
+	Nation   string      `json:"Nation,omitempty"`
+	Options  interface{} `json:"Options,omitempty"`
+	Phase    *Phase      `json:"Phase,omitempty"`
+	TimeLeft int64       `json:"TimeLeft,omitempty"`
+}
+
+type CommitRequest struct {
+	GameId  string `json:"GameId,omitempty"`
+	Nation  string `json:"Nation,omitempty"`
+	PhaseId string `json:"PhaseId,omitempty"`
+}
+
+type CreatedAPIToken struct {
+	CreatedAt time.Time `json:"CreatedAt,omitempty"`
+	Digest    string    `json:"Digest,omitempty"`
+	Id        string    `json:"Id,omitempty"`
+	Name      string    `json:"Name,omitempty"`
+	Scopes    []string  `json:"Scopes,omitempty"`
+	Token     string    `json:"Token,omitempty"`
+	UpdatedAt time.Time `json:"UpdatedAt,omitempty"`
+	UserId    string    `json:"UserId,omitempty"`
+}
+
+type GameState struct {
+	AllocationMethod      string           `json:"AllocationMethod,omitempty"`
+	BotFill               string           `json:"BotFill,omitempty"`
+	ChatFlags             map[string]int64 `json:"ChatFlags,omitempty"`
+	Closed                bool             `json:"Closed,omitempty"`
+	CreatedAt             time.Time        `json:"CreatedAt,omitempty"`
+	CurrentChatFlags      int64            `json:"CurrentChatFlags,omitempty"`
+	DeadlineAlign         bool             `json:"DeadlineAlign,omitempty"`
+	DeadlineAlignTo       int64            `json:"DeadlineAlignTo,omitempty"`
+	DeadlineLocation      string           `json:"DeadlineLocation,omitempty"`
+	DeadlineSkipDays      []int64          `json:"DeadlineSkipDays,omitempty"`
+	Deadlines             map[string]int64 `json:"Deadlines,omitempty"`
+	EndReason             string           `json:"EndReason,omitempty"`
+	EndYear               int64            `json:"EndYear,omitempty"`
+	ExpireAt              int64            `json:"ExpireAt,omitempty"`
+	ExpireDelay           int64            `json:"ExpireDelay,omitempty"`
+	Id                    string           `json:"Id,omitempty"`
+	MaximumRanking        float64          `json:"MaximumRanking,omitempty"`
+	Members               []MemberState    `json:"Members,omitempty"`
+	MinimumMembers        int64            `json:"MinimumMembers,omitempty"`
+	MinimumRanking        float64          `json:"MinimumRanking,omitempty"`
+	MinimumReliability    float64          `json:"MinimumReliability,omitempty"`
+	NMRConsequences       int64            `json:"NMRConsequences,omitempty"`
+	NonCommitConsequences int64            `json:"NonCommitConsequences,omitempty"`
+	OwnerId               string           `json:"OwnerId,omitempty"`
+	Paused                bool             `json:"Paused,omitempty"`
+	PausedAt              int64            `json:"PausedAt,omitempty"`
+	Phase                 *Phase           `json:"Phase,omitempty"`
+	Phases                int64            `json:"Phases,omitempty"`
+	Practice              bool             `json:"Practice,omitempty"`
+	PressCloseBefore      int64            `json:"PressCloseBefore,omitempty"`
+	Private               bool             `json:"Private,omitempty"`
+	Ranking               bool             `json:"Ranking,omitempty"`
+	SecretEmail           int64            `json:"SecretEmail,omitempty"`
+	SecretNation          int64            `json:"SecretNation,omitempty"`
+	SecretNickname        int64            `json:"SecretNickname,omitempty"`
+	Spectators            int64            `json:"Spectators,omitempty"`
+	StartAt               int64            `json:"StartAt,omitempty"`
+	StartDelay            int64            `json:"StartDelay,omitempty"`
+	State                 int64            `json:"State,omitempty"`
+	TimeLeft              int64            `json:"TimeLeft,omitempty"`
+	UnseenMessages        map[string]int64 `json:"UnseenMessages,omitempty"`
+	UpdatedAt             time.Time        `json:"UpdatedAt,omitempty"`
+	VacationPolicy        int64            `json:"VacationPolicy,omitempty"`
+	Variant               string           `json:"Variant,omitempty"`
+	YearChatFlags         []YearChatFlags  `json:"YearChatFlags,omitempty"`
+}
+
+type MemberState struct {
+	Autopilot        bool            `json:"Autopilot,omitempty"`
+	Bot              string          `json:"Bot,omitempty"`
+	Committed        bool            `json:"Committed,omitempty"`
+	CreatedAt        time.Time       `json:"CreatedAt,omitempty"`
+	ExtensionVote    int64           `json:"ExtensionVote,omitempty"`
+	GameId           string          `json:"GameId,omitempty"`
+	Id               string          `json:"Id,omitempty"`
+	MuteVotes        map[string]bool `json:"MuteVotes,omitempty"`
+	Muted            bool            `json:"Muted,omitempty"`
+	Nation           string          `json:"Nation,omitempty"`
+	NoOrders         bool            `json:"NoOrders,omitempty"`
+	NoWait           bool            `json:"NoWait,omitempty"`
+	Options          interface{}     `json:"Options,omitempty"`
+	PauseVote        bool            `json:"PauseVote,omitempty"`
+	PreferredNations []string        `json:"PreferredNations,omitempty"`
+	ResumeVote       bool            `json:"ResumeVote,omitempty"`
+	UpdatedAt        time.Time       `json:"UpdatedAt,omitempty"`
+	User             *User           `json:"User,omitempty"`
+	UserId           string          `json:"UserId,omitempty"`
+}
+
+type Message struct {
+	Body          string          `json:"Body,omitempty"`
+	ChannelKey    string          `json:"ChannelKey,omitempty"`
+	CreatedAt     time.Time       `json:"CreatedAt,omitempty"`
+	GameId        string          `json:"GameId,omitempty"`
+	Grey          bool            `json:"Grey,omitempty"`
+	Id            string          `json:"Id,omitempty"`
+	Press         *Press          `json:"Press,omitempty"`
+	Public        bool            `json:"Public,omitempty"`
+	RecipientIds  map[string]bool `json:"RecipientIds,omitempty"`
+	SeenBy        map[string]bool `json:"SeenBy,omitempty"`
+	SenderId      string          `json:"SenderId,omitempty"`
+	SpectatorChat bool            `json:"SpectatorChat,omitempty"`
+	UpdatedAt     time.Time       `json:"UpdatedAt,omitempty"`
+}
+
+type MessagesRequest struct {
+	Before    time.Time `json:"Before,omitempty"`
+	BeforeId  string    `json:"BeforeId,omitempty"`
+	ChannelId string    `json:"ChannelId,omitempty"`
+	GameId    string    `json:"GameId,omitempty"`
+	Limit     int64     `json:"Limit,omitempty"`
+	Query     string    `json:"Query,omitempty"`
+}
+
+type MuteRequest struct {
+	GameId   string `json:"GameId,omitempty"`
+	MemberId string `json:"MemberId,omitempty"`
+	Vote     bool   `json:"Vote,omitempty"`
+}
+
+type OrderRequest struct {
+	GameId string   `json:"GameId,omitempty"`
+	Nation string   `json:"Nation,omitempty"`
+	Order  []string `json:"Order,omitempty"`
+}
+
+type Phase struct {
+	Bounces       map[string]map[string]bool     `json:"Bounces,omitempty"`
+	CreatedAt     time.Time                      `json:"CreatedAt,omitempty"`
+	Deadline      int64                          `json:"Deadline,omitempty"`
+	Dislodgeds    map[string]Unit                `json:"Dislodgeds,omitempty"`
+	Dislodgers    map[string]string              `json:"Dislodgers,omitempty"`
+	GameId        string                         `json:"GameId,omitempty"`
+	Id            string                         `json:"Id,omitempty"`
+	Orders        map[string]map[string][]string `json:"Orders,omitempty"`
+	Ordinal       int64                          `json:"Ordinal,omitempty"`
+	Resolutions   map[string]string              `json:"Resolutions,omitempty"`
+	Resolved      bool                           `json:"Resolved,omitempty"`
+	Season        string                         `json:"Season,omitempty"`
+	SupplyCenters map[string]string              `json:"SupplyCenters,omitempty"`
+	Type          string                         `json:"Type,omitempty"`
+	Units         map[string]Unit                `json:"Units,omitempty"`
+	UpdatedAt     time.Time                      `json:"UpdatedAt,omitempty"`
+	Year          int64                          `json:"Year,omitempty"`
+}
+
+type Press struct {
+	Nations   []string   `json:"Nations,omitempty"`
+	Orders    [][]string `json:"Orders,omitempty"`
+	Reference string     `json:"Reference,omitempty"`
+	Type      string     `json:"Type,omitempty"`
+}
+
+type ReportRequest struct {
+	MessageId string `json:"MessageId,omitempty"`
+	Reason    string `json:"Reason,omitempty"`
+}
+
+type RestoreRequest struct {
+	RollbackId string `json:"RollbackId,omitempty"`
+}
+
+type RollbackRequest struct {
+	GameId  string `json:"GameId,omitempty"`
+	Ordinal int64  `json:"Ordinal,omitempty"`
+	Reason  string `json:"Reason,omitempty"`
+}
+
+type SeeRequest struct {
+	MessageId string `json:"MessageId,omitempty"`
+}
+
+type StartRequest struct {
+	GameId string `json:"GameId,omitempty"`
+}
+
+type Unit struct {
+	Nation string `json:"Nation,omitempty"`
+	Type   string `json:"Type,omitempty"`
+}
+
+type User struct {
+	Bot                  bool       `json:"Bot,omitempty"`
+	CreatedAt            time.Time  `json:"CreatedAt,omitempty"`
+	Digest               bool       `json:"Digest,omitempty"`
+	DigestMinutes        int64      `json:"DigestMinutes,omitempty"`
+	DiplicityHost        string     `json:"DiplicityHost,omitempty"`
+	Email                string     `json:"Email,omitempty"`
+	HeldDeadlines        int64      `json:"HeldDeadlines,omitempty"`
+	Id                   string     `json:"Id,omitempty"`
+	Language             string     `json:"Language,omitempty"`
+	LastDigestAt         time.Time  `json:"LastDigestAt,omitempty"`
+	LastLoginAt          time.Time  `json:"LastLoginAt,omitempty"`
+	MessageEmailDisabled bool       `json:"MessageEmailDisabled,omitempty"`
+	MissedDeadlines      int64      `json:"MissedDeadlines,omitempty"`
+	Nickname             string     `json:"Nickname,omitempty"`
+	PhaseEmailDisabled   bool       `json:"PhaseEmailDisabled,omitempty"`
+	Ranking              float64    `json:"Ranking,omitempty"`
+	UpdatedAt            time.Time  `json:"UpdatedAt,omitempty"`
+	Vacations            []Vacation `json:"Vacations,omitempty"`
+}
+
+type Vacation struct {
+	End   time.Time `json:"End,omitempty"`
+	Start time.Time `json:"Start,omitempty"`
+}
+
+type VoteRequest struct {
+	GameId  string `json:"GameId,omitempty"`
+	Minutes int64  `json:"Minutes,omitempty"`
+	Vote    bool   `json:"Vote,omitempty"`
+}
+
+type Webhook struct {
+	CreatedAt time.Time `json:"CreatedAt,omitempty"`
+	GameId    string    `json:"GameId,omitempty"`
+	Id        string    `json:"Id,omitempty"`
+	Secret    string    `json:"Secret,omitempty"`
+	URL       string    `json:"URL,omitempty"`
+	UpdatedAt time.Time `json:"UpdatedAt,omitempty"`
+	UserId    string    `json:"UserId,omitempty"`
+}
+
+type WebhookDelivery struct {
+	Attempts      int64     `json:"Attempts,omitempty"`
+	CreatedAt     time.Time `json:"CreatedAt,omitempty"`
+	Event         string    `json:"Event,omitempty"`
+	Id            string    `json:"Id,omitempty"`
+	LastError     string    `json:"LastError,omitempty"`
+	LastStatus    int64     `json:"LastStatus,omitempty"`
+	NextAttemptAt time.Time `json:"NextAttemptAt,omitempty"`
+	Payload       string    `json:"Payload,omitempty"`
+	Pending       bool      `json:"Pending,omitempty"`
+	UpdatedAt     time.Time `json:"UpdatedAt,omitempty"`
+	WebhookId     string    `json:"WebhookId,omitempty"`
+}
+
+type WebhookRequest struct {
+	GameId string `json:"GameId,omitempty"`
+	Id     string `json:"Id,omitempty"`
+	URL    string `json:"URL,omitempty"`
+}
+
+type YearChatFlags struct {
+	Flags map[string]int64 `json:"Flags,omitempty"`
+	Year  int64            `json:"Year,omitempty"`
+}
+
+// SubscribeGamesMine subscribes to ^/games/mine$.
+func (self *Client) SubscribeGamesMine(handler func(op string, payload []GameState) error) error {
+	return self.Subscribe("/games/mine", func(op string, data json.RawMessage) (err error) {
+		var payload []GameState
+		if len(data) > 0 {
+			if err = json.Unmarshal(data, &payload); err != nil {
+				return
+			}
+		}
+		return handler(op, payload)
+	})
+}
+
+// SubscribeGamesOpen subscribes to ^/games/open$.
+func (self *Client) SubscribeGamesOpen(handler func(op string, payload []GameState) error) error {
+	return self.Subscribe("/games/open", func(op string, data json.RawMessage) (err error) {
+		var payload []GameState
+		if len(data) > 0 {
+			if err = json.Unmarshal(data, &payload); err != nil {
+				return
+			}
+		}
+		return handler(op, payload)
+	})
+}
+
+// SubscribeGamesClosed subscribes to ^/games/closed$.
+func (self *Client) SubscribeGamesClosed(handler func(op string, payload []GameState) error) error {
+	return self.Subscribe("/games/closed", func(op string, data json.RawMessage) (err error) {
+		var payload []GameState
+		if len(data) > 0 {
+			if err = json.Unmarshal(data, &payload); err != nil {
+				return
+			}
+		}
+		return handler(op, payload)
+	})
+}
+
+// SubscribeGamesFinished subscribes to ^/games/finished$.
+func (self *Client) SubscribeGamesFinished(handler func(op string, payload []GameState) error) error {
+	return self.Subscribe("/games/finished", func(op string, data json.RawMessage) (err error) {
+		var payload []GameState
+		if len(data) > 0 {
+			if err = json.Unmarshal(data, &payload); err != nil {
+				return
+			}
+		}
+		return handler(op, payload)
+	})
+}
+
+// SubscribeBotPhases subscribes to ^/bot/phases$.
+func (self *Client) SubscribeBotPhases(handler func(op string, payload []BotPhase) error) error {
+	return self.Subscribe("/bot/phases", func(op string, data json.RawMessage) (err error) {
+		var payload []BotPhase
+		if len(data) > 0 {
+			if err = json.Unmarshal(data, &payload); err != nil {
+				return
+			}
+		}
+		return handler(op, payload)
+	})
+}
+
+// SubscribeUser subscribes to ^/user$.
+func (self *Client) SubscribeUser(handler func(op string, payload *User) error) error {
+	return self.Subscribe("/user", func(op string, data json.RawMessage) (err error) {
+		var payload *User
+		if len(data) > 0 {
+			if err = json.Unmarshal(data, &payload); err != nil {
+				return
+			}
+		}
+		return handler(op, payload)
+	})
+}
+
+// UpdateUser sends Update to ^/user$.
+func (self *Client) UpdateUser(payload *User) error {
+	return self.Send("Update", "/user", payload)
+}
+
+// SubscribeAPITokens subscribes to ^/user/tokens$.
+func (self *Client) SubscribeAPITokens(handler func(op string, payload []APIToken) error) error {
+	return self.Subscribe("/user/tokens", func(op string, data json.RawMessage) (err error) {
+		var payload []APIToken
+		if len(data) > 0 {
+			if err = json.Unmarshal(data, &payload); err != nil {
+				return
+			}
+		}
+		return handler(op, payload)
+	})
+}
+
+// DeleteAPITokens sends Delete to ^/user/tokens$.
+func (self *Client) DeleteAPITokens(payload *APITokenRequest) error {
+	return self.Send("Delete", "/user/tokens", payload)
+}
+
+// SubscribeGamePhase subscribes to ^/games/(.+)/(\d+)$.
+func (self *Client) SubscribeGamePhase(gameId string, ordinal string, handler func(op string, payload *GameState) error) error {
+	return self.Subscribe(fmt.Sprintf("/games/%v/%v", gameId, ordinal), func(op string, data json.RawMessage) (err error) {
+		var payload *GameState
+		if len(data) > 0 {
+			if err = json.Unmarshal(data, &payload); err != nil {
+				return
+			}
+		}
+		return handler(op, payload)
+	})
+}
+
+// SubscribeGameMessages subscribes to ^/games/(.+)/messages$.
+func (self *Client) SubscribeGameMessages(gameId string, handler func(op string, payload []Message) error) error {
+	return self.Subscribe(fmt.Sprintf("/games/%v/messages", gameId), func(op string, data json.RawMessage) (err error) {
+		var payload []Message
+		if len(data) > 0 {
+			if err = json.Unmarshal(data, &payload); err != nil {
+				return
+			}
+		}
+		return handler(op, payload)
+	})
+}
+
+// CreateGameMessages sends Create to ^/games/(.+)/messages$.
+func (self *Client) CreateGameMessages(gameId string, payload *Message) error {
+	return self.Send("Create", fmt.Sprintf("/games/%v/messages", gameId), payload)
+}
+
+// CreateGameSpectators sends Create to ^/games/(.+)/spectators$.
+func (self *Client) CreateGameSpectators(gameId string) error {
+	return self.Send("Create", fmt.Sprintf("/games/%v/spectators", gameId), nil)
+}
+
+// DeleteGameSpectators sends Delete to ^/games/(.+)/spectators$.
+func (self *Client) DeleteGameSpectators(gameId string) error {
+	return self.Send("Delete", fmt.Sprintf("/games/%v/spectators", gameId), nil)
+}
+
+// SubscribeGame subscribes to ^/games/(.+)$.
+func (self *Client) SubscribeGame(gameId string, handler func(op string, payload *GameState) error) error {
+	return self.Subscribe(fmt.Sprintf("/games/%v", gameId), func(op string, data json.RawMessage) (err error) {
+		var payload *GameState
+		if len(data) > 0 {
+			if err = json.Unmarshal(data, &payload); err != nil {
+				return
+			}
+		}
+		return handler(op, payload)
+	})
+}
+
+// DeleteGame sends Delete to ^/games/(.+)$.
+func (self *Client) DeleteGame(gameId string) error {
+	return self.Send("Delete", fmt.Sprintf("/games/%v", gameId), nil)
+}
+
+// UpdateGame sends Update to ^/games/(.+)$.
+func (self *Client) UpdateGame(gameId string, payload *GameState) error {
+	return self.Send("Update", fmt.Sprintf("/games/%v", gameId), payload)
+}
+
+// CreateGames sends Create to ^/games$.
+func (self *Client) CreateGames(payload *GameState) error {
+	return self.Send("Create", "/games", payload)
+}
+
+// SetOrder calls the SetOrder RPC.
+func (self *Client) SetOrder(req *OrderRequest) error {
+	return self.Call("SetOrder", req, nil)
+}
+
+// Commit calls the Commit RPC.
+func (self *Client) Commit(req *CommitRequest) error {
+	return self.Call("Commit", req, nil)
+}
+
+// Uncommit calls the Uncommit RPC.
+func (self *Client) Uncommit(req *CommitRequest) error {
+	return self.Call("Uncommit", req, nil)
+}
+
+// See calls the See RPC.
+func (self *Client) See(req *SeeRequest) error {
+	return self.Call("See", req, nil)
+}
+
+// StartWithBots calls the StartWithBots RPC.
+func (self *Client) StartWithBots(req *StartRequest) error {
+	return self.Call("StartWithBots", req, nil)
+}
+
+// PracticeRollback calls the PracticeRollback RPC.
+func (self *Client) PracticeRollback(req *RollbackRequest) error {
+	return self.Call("PracticeRollback", req, nil)
+}
+
+// PracticeRestore calls the PracticeRestore RPC.
+func (self *Client) PracticeRestore(req *RestoreRequest) error {
+	return self.Call("PracticeRestore", req, nil)
+}
+
+// VotePause calls the VotePause RPC.
+func (self *Client) VotePause(req *VoteRequest) error {
+	return self.Call("VotePause", req, nil)
+}
+
+// VoteResume calls the VoteResume RPC.
+func (self *Client) VoteResume(req *VoteRequest) error {
+	return self.Call("VoteResume", req, nil)
+}
+
+// VoteExtension calls the VoteExtension RPC.
+func (self *Client) VoteExtension(req *VoteRequest) error {
+	return self.Call("VoteExtension", req, nil)
+}
+
+// VoteMute calls the VoteMute RPC.
+func (self *Client) VoteMute(req *MuteRequest) error {
+	return self.Call("VoteMute", req, nil)
+}
+
+// ReportMessage calls the ReportMessage RPC.
+func (self *Client) ReportMessage(req *ReportRequest) error {
+	return self.Call("ReportMessage", req, nil)
+}
+
+// GetMessages calls the GetMessages RPC.
+func (self *Client) GetMessages(req *MessagesRequest) (result []Message, err error) {
+	err = self.Call("GetMessages", req, &result)
+	return
+}
+
+// SearchMessages calls the SearchMessages RPC.
+func (self *Client) SearchMessages(req *MessagesRequest) (result []Message, err error) {
+	err = self.Call("SearchMessages", req, &result)
+	return
+}
+
+// GetCalendarURL calls the GetCalendarURL RPC.
+func (self *Client) GetCalendarURL() (result string, err error) {
+	err = self.Call("GetCalendarURL", nil, &result)
+	return
+}
+
+// CreateWebhook calls the CreateWebhook RPC.
+func (self *Client) CreateWebhook(req *WebhookRequest) (result *Webhook, err error) {
+	err = self.Call("CreateWebhook", req, &result)
+	return
+}
+
+// DeleteWebhook calls the DeleteWebhook RPC.
+func (self *Client) DeleteWebhook(req *WebhookRequest) error {
+	return self.Call("DeleteWebhook", req, nil)
+}
+
+// GetWebhooks calls the GetWebhooks RPC.
+func (self *Client) GetWebhooks() (result []Webhook, err error) {
+	err = self.Call("GetWebhooks", nil, &result)
+	return
+}
+
+// GetWebhookDeliveries calls the GetWebhookDeliveries RPC.
+func (self *Client) GetWebhookDeliveries(req *WebhookRequest) (result []WebhookDelivery, err error) {
+	err = self.Call("GetWebhookDeliveries", req, &result)
+	return
+}
+
+// CreateAPIToken calls the CreateAPIToken RPC.
+func (self *Client) CreateAPIToken(req *APITokenRequest) (result *CreatedAPIToken, err error) {
+	err = self.Call("CreateAPIToken", req, &result)
+	return
+}
@@ -0,0 +1,57 @@
+package client
+
+import (
+	"encoding/json"
+)
+
+/*
+Option is one choice when building an order, with Type being Province, SrcProvince, OrderType or UnitType.
+*/
+type Option struct {
+	Type string
+	Next Options
+}
+
+/*
+Options are the choices a member has when giving orders, like MemberState.Options and BotPhase.Options.
+*/
+type Options map[string]Option
+
+/*
+DecodeOptions decodes the options as found in MemberState.Options and BotPhase.Options.
+*/
+func DecodeOptions(raw interface{}) (result Options, err error) {
+	b, err := json.Marshal(raw)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(b, &result)
+	return
+}
+
+/*
+Orders returns all complete orders in the options, by the province the unit is in, built the way the web client builds them.
+*/
+func (self Options) Orders() (result map[string][][]string) {
+	result = map[string][][]string{}
+	for prov, option := range self {
+		option.Next.collect(prov, []string{}, func(order []string) {
+			result[prov] = append(result[prov], order)
+		})
+	}
+	return
+}
+
+func (self Options) collect(src string, order []string, f func(order []string)) {
+	if len(self) == 0 {
+		f(append([]string{src}, order...))
+		return
+	}
+	for value, option := range self {
+		if option.Type == "SrcProvince" {
+			option.Next.collect(value, order, f)
+		} else {
+			option.Next.collect(src, append(append([]string{}, order...), value), f)
+		}
+	}
+}
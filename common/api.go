@@ -0,0 +1,257 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"code.google.com/p/go.net/websocket"
+	"github.com/gorilla/mux"
+	"github.com/zond/diplicity/translation"
+	"github.com/zond/kcwraps/kol"
+	"github.com/zond/kcwraps/subs"
+	"github.com/zond/wsubs/gosubs"
+)
+
+/*
+APIError is the body of all failed API responses.
+*/
+type APIError struct {
+	Status int
+	Error  string
+}
+
+/*
+apiContext lets the WebSocket handlers serve plain HTTP requests.
+
+The request data is the JSON body, or the query parameters of GET requests, with the route variables added to it.
+It has no connection or subscription pack, so only handlers that don't subscribe can use it.
+*/
+type apiContext struct {
+	*Web
+	db           *kol.DB
+	principal    string
+	match        []string
+	data         gosubs.JSON
+	translations map[string]string
+}
+
+func (self *apiContext) Conn() *websocket.Conn {
+	return nil
+}
+
+func (self *apiContext) Pack() *subs.Pack {
+	return nil
+}
+
+func (self *apiContext) Match() []string {
+	return self.match
+}
+
+func (self *apiContext) Data() gosubs.JSON {
+	return self.data
+}
+
+func (self *apiContext) Principal() string {
+	return self.principal
+}
+
+func (self *apiContext) DB() *kol.DB {
+	return self.db
+}
+
+func (self *apiContext) Diet() SkinnyContext {
+	return skinnyWSContext{WSContext: self}
+}
+
+func (self apiContext) BetweenTransactions(f func(c WSContext)) {
+	self.db.BetweenTransactions(func(d *kol.DB) {
+		self.db = d
+		f(&self)
+	})
+}
+
+func (self apiContext) Transact(f func(c WSContext) error) error {
+	return self.db.Transact(func(d *kol.DB) error {
+		self.db = d
+		return f(&self)
+	})
+}
+
+func (self *apiContext) I(phrase string, args ...interface{}) (result string, err error) {
+	pattern, ok := self.translations[phrase]
+	if !ok {
+		err = fmt.Errorf("Found no translation for %v", phrase)
+		result = err.Error()
+		return
+	}
+	if len(args) > 0 {
+		result = fmt.Sprintf(pattern, args...)
+		return
+	}
+	result = pattern
+	return
+}
+
+/*
+APIHandler is an API endpoint, see Web.HandleAPI.
+*/
+type APIHandler struct {
+	auth  bool
+	scope string
+}
+
+/*
+Auth makes the endpoint refuse requests without a valid token.
+*/
+func (self *APIHandler) Auth() *APIHandler {
+	self.auth = true
+	return self
+}
+
+/*
+Scope lets requests authenticated with API tokens having scope use the endpoint.
+*/
+func (self *APIHandler) Scope(scope string) *APIHandler {
+	self.scope = scope
+	return self
+}
+
+/*
+apiPrincipal returns the principal and scopes of the personal API token or gosubs.Token provided as a bearer token in the Authorization header,
+or in the token parameter.
+*/
+func (self *Web) apiPrincipal(r *http.Request) (result string, scopes tokenScopes, err error) {
+	encoded := r.URL.Query().Get("token")
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		encoded = strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+	}
+	if encoded == "" {
+		return
+	}
+	if strings.HasPrefix(encoded, APITokenPrefix) {
+		scopes.apiToken = true
+		result, scopes.scopes, err = self.resolveAPIToken(encoded)
+		return
+	}
+	token, err := gosubs.DecodeToken(self.secret, encoded)
+	if err != nil {
+		return
+	}
+	if token.Timeout.Before(time.Now()) {
+		err = fmt.Errorf("Token timed out at %v", token.Timeout)
+		return
+	}
+	result = token.Principal
+	return
+}
+
+func apiData(r *http.Request, vars map[string]string) (result map[string]interface{}, err error) {
+	result = map[string]interface{}{}
+	if r.Method == "GET" || r.Method == "DELETE" {
+		for key, values := range r.URL.Query() {
+			if key == "token" || len(values) == 0 {
+				continue
+			}
+			// Let numbers and booleans through as such, everything else is a string
+			var value interface{}
+			if err := json.Unmarshal([]byte(values[0]), &value); err == nil {
+				switch value.(type) {
+				case float64, bool:
+					result[key] = value
+					continue
+				}
+			}
+			result[key] = values[0]
+		}
+	} else if err = json.NewDecoder(r.Body).Decode(&result); err == io.EOF {
+		err = nil
+	} else if err != nil {
+		return
+	}
+	for key, value := range vars {
+		result[key] = value
+	}
+	return
+}
+
+func renderAPIError(c *HTTPContext, status int, err error) error {
+	b, err := json.MarshalIndent(APIError{
+		Status: status,
+		Error:  err.Error(),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	c.SetContentType("application/json; charset=UTF-8", false)
+	c.Resp().WriteHeader(status)
+	_, err = c.Resp().Write(b)
+	return err
+}
+
+var apiPathVarReg = regexp.MustCompile("\\{([^}:]+)(:[^}]*)?\\}")
+
+/*
+HandleAPI serves f, written for the WebSocket router, as a JSON endpoint for method requests to the path template path of router.
+
+Like for the WebSocket router, Match() of the context is the requested path followed by the values of the path variables, in the order they appear in path.
+
+The results of f are rendered as JSON, and errors as APIError with status 404 for missing objects and 400 otherwise.
+*/
+func (self *Web) HandleAPI(router *mux.Router, method, path string, f func(c WSContext) (result interface{}, err error)) (result *APIHandler) {
+	result = &APIHandler{}
+	varNames := []string{}
+	for _, match := range apiPathVarReg.FindAllStringSubmatch(path, -1) {
+		varNames = append(varNames, match[1])
+	}
+	self.Handle(router.Path(path).Methods(method), func(c *HTTPContext) (err error) {
+		principal, scopes, err := self.apiPrincipal(c.Req())
+		if err != nil {
+			return renderAPIError(c, 401, err)
+		}
+		if result.auth && principal == "" {
+			return renderAPIError(c, 401, fmt.Errorf("Unauthorized"))
+		}
+		if !scopes.allows(result.scope) {
+			return renderAPIError(c, 403, fmt.Errorf("Not allowed without the %#v scope", result.scope))
+		}
+		data, err := apiData(c.Req(), c.Vars())
+		if err != nil {
+			return renderAPIError(c, 400, err)
+		}
+		match := []string{c.Req().URL.Path}
+		for _, name := range varNames {
+			match = append(match, c.Vars()[name])
+		}
+		ctx := &apiContext{
+			Web:          self,
+			db:           self.db,
+			principal:    principal,
+			match:        match,
+			data:         gosubs.JSON{Data: data},
+			translations: translation.GetTranslations(GetLanguage(c.Req())),
+		}
+		res, err := f(ctx)
+		if err == kol.NotFound {
+			return renderAPIError(c, 404, err)
+		} else if err != nil {
+			return renderAPIError(c, 400, err)
+		}
+		return c.RenderJSON(res)
+	})
+	return
+}
+
+/*
+APIFunc adapts WebSocket resource handlers, that return nothing but errors, to HandleAPI.
+*/
+func APIFunc(f func(c WSContext) error) func(c WSContext) (result interface{}, err error) {
+	return func(c WSContext) (result interface{}, err error) {
+		err = f(c)
+		return
+	}
+}
@@ -0,0 +1,109 @@
+package common
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/zond/kcwraps/kol"
+	"github.com/zond/wsubs/gosubs"
+)
+
+const (
+	APITokenPrefix = "dpt_"
+	ScopeRead      = "read"
+	ScopeOrders    = "orders"
+	ScopeMessages  = "messages"
+	apiTokenParam  = "api_token"
+)
+
+var APIScopes = []string{ScopeRead, ScopeOrders, ScopeMessages}
+
+/*
+APITokenResolver returns the principal and scopes of a personal API token.
+*/
+type APITokenResolver func(d *kol.DB, token string) (principal string, scopes []string, err error)
+
+/*
+tokenScopes are the scopes of a request.
+
+Requests from browser sessions aren't limited by scopes. Requests authenticated with API tokens may subscribe and use ScopeRead handlers,
+may use handlers of the scopes of their token, and may not use handlers without a scope.
+*/
+type tokenScopes struct {
+	apiToken bool
+	scopes   []string
+}
+
+func (self tokenScopes) allows(required string) bool {
+	if !self.apiToken || required == ScopeRead {
+		return true
+	}
+	for _, scope := range self.scopes {
+		if scope == required {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+connectionScopes returns the scopes of the WebSocket connection of r.
+
+Connections authenticated with API tokens get the current scopes of their token, and an error if it has been deleted since they connected.
+*/
+func (self *Web) connectionScopes(r *http.Request) (result tokenScopes, err error) {
+	if r == nil {
+		return
+	}
+	if token := r.URL.Query().Get(apiTokenParam); token != "" {
+		result.apiToken = true
+		_, result.scopes, err = self.resolveAPIToken(token)
+	}
+	return
+}
+
+func (self *Web) SetAPITokenResolver(resolver APITokenResolver) *Web {
+	self.apiTokenResolver = resolver
+	return self
+}
+
+func (self *Web) resolveAPIToken(token string) (principal string, scopes []string, err error) {
+	if self.apiTokenResolver == nil {
+		err = fmt.Errorf("API tokens are not supported")
+		return
+	}
+	return self.apiTokenResolver(self.db, token)
+}
+
+/*
+ServeHTTP exchanges personal API tokens in the token parameter of WebSocket handshakes for regular tokens, and marks the connection
+with the API token, so that each operation can check that it is still valid, before handing it to the WebSocket router.
+*/
+func (self *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	// Only we get to say what API token a connection has
+	query.Del(apiTokenParam)
+	if token := query.Get("token"); strings.HasPrefix(token, APITokenPrefix) {
+		principal, _, err := self.web.resolveAPIToken(token)
+		if err != nil {
+			w.WriteHeader(401)
+			fmt.Fprintln(w, err)
+			return
+		}
+		exchanged := &gosubs.Token{
+			Principal: principal,
+			Timeout:   time.Now().Add(time.Second * 10),
+		}
+		if err = exchanged.Encode(self.web.secret); err != nil {
+			w.WriteHeader(500)
+			fmt.Fprintln(w, err)
+			return
+		}
+		query.Set("token", exchanged.Encoded)
+		query.Set(apiTokenParam, token)
+	}
+	r.URL.RawQuery = query.Encode()
+	self.Router.ServeHTTP(w, r)
+}
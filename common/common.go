@@ -34,6 +34,8 @@ const (
 
 type Mailer interface {
 	SendMail(fromName, replyTo, subject, message string, recips []string) error
+	SendMessage(mail *Mail) error
+	RenderMail(mail *Mail, name, language string, data interface{}) error
 	ReceiveAddress() string
 	SendAddress() string
 }
@@ -100,6 +102,15 @@ const (
 	ReliabilityHit Consequence = 1 << iota
 	NoWait
 	Surrender
+	Autopilot
+)
+
+type VacationPolicy int
+
+const (
+	VacationDisallowed VacationPolicy = iota
+	VacationExtend
+	VacationHold
 )
 
 type ChatFlag int
@@ -108,6 +119,7 @@ const (
 	ChatPrivate = 1 << iota
 	ChatGroup
 	ChatConference
+	ChatGrey
 )
 
 type ChatChannel map[dip.Nation]bool
@@ -139,6 +151,10 @@ var ConsequenceOptions = []ConsequenceOption{
 		Id:   Surrender,
 		Name: "Surrender",
 	},
+	ConsequenceOption{
+		Id:   Autopilot,
+		Name: "Autopilot",
+	},
 }
 
 type ChatFlagOption struct {
@@ -160,6 +176,10 @@ var ChatFlagOptions = []ChatFlagOption{
 		Id:   ChatConference,
 		Name: "Conference press",
 	},
+	ChatFlagOption{
+		Id:   ChatGrey,
+		Name: "Grey press",
+	},
 }
 
 const (
@@ -402,13 +422,6 @@ const (
 	UnsubscribePhaseEmail
 )
 
-const (
-	EmailTemplate = `%v
-----
-%v
-%v`
-)
-
 type UnsubscribeTag struct {
 	T int
 	U kol.Id
@@ -451,3 +464,49 @@ func DecodeUnsubscribeTag(secret string, s string) (result *UnsubscribeTag, err
 	result = tag
 	return
 }
+
+/*
+CalendarTag identifies the user of a secret calendar feed URL.
+*/
+type CalendarTag struct {
+	U kol.Id
+	H []byte
+}
+
+func (self *CalendarTag) Hash(secret string) []byte {
+	h := sha1.New()
+	h.Write([]byte("calendar"))
+	h.Write(self.U)
+	h.Write([]byte(secret))
+	return h.Sum(nil)
+}
+
+func (self *CalendarTag) Encode() (result string, err error) {
+	buf := &bytes.Buffer{}
+	baseEnc := base64.NewEncoder(base64.URLEncoding, buf)
+	gobEnc := gob.NewEncoder(baseEnc)
+	if err = gobEnc.Encode(self); err != nil {
+		return
+	}
+	if err = baseEnc.Close(); err != nil {
+		return
+	}
+	result = buf.String()
+	return
+}
+
+func DecodeCalendarTag(secret string, s string) (result *CalendarTag, err error) {
+	buf := bytes.NewBufferString(s)
+	dec := gob.NewDecoder(base64.NewDecoder(base64.URLEncoding, buf))
+	tag := &CalendarTag{}
+	if err = dec.Decode(tag); err != nil {
+		return
+	}
+	wanted := tag.Hash(secret)
+	if len(wanted) != len(tag.H) || subtle.ConstantTimeCompare(wanted, tag.H) != 1 {
+		err = fmt.Errorf("%+v has wrong hash, wanted %v", tag, wanted)
+		return
+	}
+	result = tag
+	return
+}
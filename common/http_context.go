@@ -33,6 +33,10 @@ func (self *HTTPContext) DB() *kol.DB {
 	return self.web.db
 }
 
+func (self *HTTPContext) Diet() SkinnyContext {
+	return self.web.Diet()
+}
+
 func (self *HTTPContext) Secret() string {
 	return self.web.secret
 }
@@ -182,6 +186,15 @@ func (self *HTTPContext) ConsequenceMap() string {
 		"ReliabilityHit": ReliabilityHit,
 		"NoWait":         NoWait,
 		"Surrender":      Surrender,
+		"Autopilot":      Autopilot,
+	})
+}
+
+func (self *HTTPContext) VacationPolicyMap() string {
+	return gosubs.Prettify(map[string]VacationPolicy{
+		"Disallowed": VacationDisallowed,
+		"Extend":     VacationExtend,
+		"Hold":       VacationHold,
 	})
 }
 
@@ -190,6 +203,7 @@ func (self *HTTPContext) ChatFlagMap() string {
 		"ChatPrivate":    ChatPrivate,
 		"ChatGroup":      ChatGroup,
 		"ChatConference": ChatConference,
+		"ChatGrey":       ChatGrey,
 	})
 }
 
@@ -272,6 +286,8 @@ func (self *HTTPContext) Consequence(s string) Consequence {
 		return NoWait
 	case "Surrender":
 		return Surrender
+	case "Autopilot":
+		return Autopilot
 	}
 	panic(fmt.Errorf("Unknown consequence flag %v", s))
 }
@@ -297,6 +313,8 @@ func (self *HTTPContext) ChatFlag(s string) string {
 		rval = ChatGroup
 	case "Conference":
 		rval = ChatConference
+	case "Grey":
+		rval = ChatGrey
 	}
 	return fmt.Sprint(rval)
 }
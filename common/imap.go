@@ -0,0 +1,182 @@
+package common
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jhillyerd/go.enmime"
+)
+
+var imapLiteralReg = regexp.MustCompile("\\{(\\d+)\\}$")
+
+/*
+IMAPSource polls a mailbox on a generic IMAP server for unseen mail, and marks the mail it has handled as seen.
+*/
+type IMAPSource struct {
+	Addr     string
+	Account  string
+	Password string
+	Mailbox  string
+	TLS      bool
+	Interval time.Duration
+}
+
+func (self *IMAPSource) String() string {
+	return fmt.Sprintf("imap:%v@%v/%v", self.Account, self.Addr, self.Mailbox)
+}
+
+func (self *IMAPSource) Start(handler func(msg *enmime.MIMEBody) error, errorHandler func(error)) (err error) {
+	if self.Mailbox == "" {
+		self.Mailbox = "INBOX"
+	}
+	if self.Interval == 0 {
+		self.Interval = time.Minute
+	}
+	// Make sure we can log in before going into the background
+	conn, err := self.dial()
+	if err != nil {
+		return
+	}
+	conn.close()
+	go func() {
+		for {
+			if err := self.poll(handler, errorHandler); err != nil {
+				errorHandler(err)
+			}
+			time.Sleep(self.Interval)
+		}
+	}()
+	return
+}
+
+func (self *IMAPSource) dial() (result *imapConn, err error) {
+	var conn net.Conn
+	if self.TLS {
+		host, _, _ := net.SplitHostPort(self.Addr)
+		conn, err = tls.Dial("tcp", self.Addr, &tls.Config{ServerName: host})
+	} else {
+		conn, err = net.Dial("tcp", self.Addr)
+	}
+	if err != nil {
+		return
+	}
+	result = &imapConn{
+		conn:   conn,
+		reader: bufio.NewReader(conn),
+	}
+	if _, err = result.reader.ReadString('\n'); err != nil {
+		conn.Close()
+		return
+	}
+	if _, _, err = result.command("LOGIN %v %v", imapQuote(self.Account), imapQuote(self.Password)); err != nil {
+		conn.Close()
+		return
+	}
+	return
+}
+
+func (self *IMAPSource) poll(handler func(msg *enmime.MIMEBody) error, errorHandler func(error)) (err error) {
+	conn, err := self.dial()
+	if err != nil {
+		return
+	}
+	defer conn.close()
+	if _, _, err = conn.command("SELECT %v", imapQuote(self.Mailbox)); err != nil {
+		return
+	}
+	lines, _, err := conn.command("UID SEARCH UNSEEN")
+	if err != nil {
+		return
+	}
+	uids := []string{}
+	for _, line := range lines {
+		if strings.HasPrefix(line, "* SEARCH") {
+			uids = append(uids, strings.Fields(line)[2:]...)
+		}
+	}
+	for _, uid := range uids {
+		var literals [][]byte
+		if _, literals, err = conn.command("UID FETCH %v BODY.PEEK[]", uid); err != nil {
+			return
+		}
+		for _, literal := range literals {
+			msg, err := parseMail(bytes.NewReader(literal))
+			if err == nil {
+				err = handler(msg)
+			}
+			if err != nil {
+				errorHandler(fmt.Errorf("%v: Failed handling %v: %v", self, uid, err))
+			}
+		}
+		// Handled mail is marked as seen even when the handler failed, since it would fail the same way next time
+		if _, _, err = conn.command("UID STORE %v +FLAGS (\\Seen)", uid); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func imapQuote(s string) string {
+	return "\"" + strings.Replace(strings.Replace(s, "\\", "\\\\", -1), "\"", "\\\"", -1) + "\""
+}
+
+type imapConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	tag    int
+}
+
+func (self *imapConn) close() {
+	self.command("LOGOUT")
+	self.conn.Close()
+}
+
+/*
+command sends a command and returns the untagged response lines and any literals in them.
+*/
+func (self *imapConn) command(format string, args ...interface{}) (lines []string, literals [][]byte, err error) {
+	self.tag++
+	tag := fmt.Sprintf("a%v", self.tag)
+	if _, err = fmt.Fprintf(self.conn, "%v %v\r\n", tag, fmt.Sprintf(format, args...)); err != nil {
+		return
+	}
+	for {
+		var line string
+		if line, err = self.reader.ReadString('\n'); err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		for {
+			match := imapLiteralReg.FindStringSubmatch(line)
+			if match == nil {
+				break
+			}
+			size, _ := strconv.Atoi(match[1])
+			literal := make([]byte, size)
+			if _, err = io.ReadFull(self.reader, literal); err != nil {
+				return
+			}
+			literals = append(literals, literal)
+			var rest string
+			if rest, err = self.reader.ReadString('\n'); err != nil {
+				return
+			}
+			line = line[:len(line)-len(match[0])] + strings.TrimRight(rest, "\r\n")
+		}
+		if strings.HasPrefix(line, tag+" ") {
+			if status := strings.Fields(line); len(status) < 2 || status[1] != "OK" {
+				err = fmt.Errorf("IMAP command %#v failed: %v", strings.Fields(format)[0], line)
+			}
+			return
+		}
+		lines = append(lines, line)
+	}
+}
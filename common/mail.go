@@ -0,0 +1,112 @@
+package common
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"strings"
+)
+
+/*
+Mail is a rendered outgoing mail with a text part and an optional HTML part.
+*/
+type Mail struct {
+	FromName    string
+	ReplyTo     string
+	To          []string
+	Subject     string
+	Text        string
+	HTML        string
+	Unsubscribe string
+}
+
+/*
+MailSection is a per game section of a mail summarizing several games.
+*/
+type MailSection struct {
+	Title  string
+	GameId string
+	Lines  []string
+}
+
+/*
+MailData is what the templates in templates/mail are rendered with.
+*/
+type MailData struct {
+	Translator
+	Host           string
+	GameId         string
+	ChannelId      string
+	Body           string
+	UnsubscribeTag string
+	Sections       []MailSection
+}
+
+func (self MailData) UnsubscribeURL() string {
+	return fmt.Sprintf("http://%v/unsubscribe/%v", self.Host, self.UnsubscribeTag)
+}
+
+func writeBase64(buf *bytes.Buffer, data []byte) {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 76 {
+		buf.WriteString(encoded[:76])
+		buf.WriteString("\r\n")
+		encoded = encoded[76:]
+	}
+	buf.WriteString(encoded)
+	buf.WriteString("\r\n")
+}
+
+func writePart(w *multipart.Writer, contentType string, data []byte) (err error) {
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", contentType)
+	header.Set("Content-Transfer-Encoding", "base64")
+	part, err := w.CreatePart(header)
+	if err != nil {
+		return
+	}
+	buf := &bytes.Buffer{}
+	writeBase64(buf, data)
+	_, err = part.Write(buf.Bytes())
+	return
+}
+
+/*
+Bytes returns the mail as an RFC 822 message sent from the address from.
+
+Mails with only a text part are sent as plain text, mails with an HTML part as multipart/alternative.
+*/
+func (self *Mail) Bytes(from string) (result []byte, err error) {
+	headers := []string{
+		"MIME-Version: 1.0",
+		fmt.Sprintf("Reply-To: %v", self.ReplyTo),
+		fmt.Sprintf("From: %v <%v>", mime.QEncoding.Encode("utf-8", self.FromName), from),
+		fmt.Sprintf("To: %v", strings.Join(self.To, ", ")),
+		fmt.Sprintf("Subject: %v", mime.QEncoding.Encode("utf-8", self.Subject)),
+	}
+	if self.Unsubscribe != "" {
+		headers = append(headers, fmt.Sprintf("List-Unsubscribe: <%v>", self.Unsubscribe))
+	}
+	body := &bytes.Buffer{}
+	if self.HTML == "" {
+		headers = append(headers, "Content-Type: text/plain; charset=\"utf-8\"", "Content-Transfer-Encoding: base64")
+		writeBase64(body, []byte(self.Text))
+	} else {
+		alternative := multipart.NewWriter(body)
+		if err = writePart(alternative, "text/plain; charset=\"utf-8\"", []byte(self.Text)); err != nil {
+			return
+		}
+		if err = writePart(alternative, "text/html; charset=\"utf-8\"", []byte(self.HTML)); err != nil {
+			return
+		}
+		if err = alternative.Close(); err != nil {
+			return
+		}
+		headers = append(headers, fmt.Sprintf("Content-Type: multipart/alternative; boundary=%v", alternative.Boundary()))
+	}
+	result = append([]byte(strings.Join(headers, "\r\n")+"\r\n\r\n"), body.Bytes()...)
+	return
+}
@@ -0,0 +1,104 @@
+package common
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"strings"
+
+	"github.com/jhillyerd/go.enmime"
+)
+
+/*
+ListenerSource accepts mail delivered over LMTP, or plain SMTP if SMTP is set, from a local mail server.
+
+It accepts all recipients and does no authentication, so it should only listen to addresses reachable by trusted servers.
+*/
+type ListenerSource struct {
+	Addr     string
+	SMTP     bool
+	listener net.Listener
+}
+
+func (self *ListenerSource) String() string {
+	if self.SMTP {
+		return fmt.Sprintf("smtp:%v", self.Addr)
+	}
+	return fmt.Sprintf("lmtp:%v", self.Addr)
+}
+
+func (self *ListenerSource) Start(handler func(msg *enmime.MIMEBody) error, errorHandler func(error)) (err error) {
+	if self.listener, err = net.Listen("tcp", self.Addr); err != nil {
+		return
+	}
+	go func() {
+		for {
+			conn, err := self.listener.Accept()
+			if err != nil {
+				errorHandler(err)
+				return
+			}
+			go self.serve(conn, handler, errorHandler)
+		}
+	}()
+	return
+}
+
+func (self *ListenerSource) serve(conn net.Conn, handler func(msg *enmime.MIMEBody) error, errorHandler func(error)) {
+	defer conn.Close()
+	text := textproto.NewConn(conn)
+	text.PrintfLine("220 %v ready", self)
+	recipients := 0
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		switch strings.ToUpper(strings.SplitN(strings.SplitN(line, " ", 2)[0], ":", 2)[0]) {
+		case "LHLO", "EHLO", "HELO":
+			text.PrintfLine("250 diplicity")
+		case "MAIL", "RSET":
+			recipients = 0
+			text.PrintfLine("250 OK")
+		case "RCPT":
+			recipients++
+			text.PrintfLine("250 OK")
+		case "NOOP":
+			text.PrintfLine("250 OK")
+		case "DATA":
+			if recipients == 0 {
+				text.PrintfLine("503 No recipients")
+				continue
+			}
+			text.PrintfLine("354 Go ahead")
+			data, err := ioutil.ReadAll(text.DotReader())
+			if err != nil {
+				return
+			}
+			reply := "250 OK"
+			msg, err := parseMail(bytes.NewReader(data))
+			if err == nil {
+				err = handler(msg)
+			}
+			if err != nil {
+				errorHandler(fmt.Errorf("%v: Failed handling mail: %v", self, err))
+				reply = "554 Not accepted"
+			}
+			// LMTP wants one reply per recipient, SMTP just one
+			if self.SMTP {
+				recipients = 1
+			}
+			for i := 0; i < recipients; i++ {
+				text.PrintfLine("%v", reply)
+			}
+			recipients = 0
+		case "QUIT":
+			text.PrintfLine("221 Bye")
+			return
+		default:
+			text.PrintfLine("502 Not implemented")
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package common
+
+import (
+	"time"
+
+	"github.com/zond/kcwraps/kol"
+	"github.com/zond/wsubs/gosubs"
+)
+
+const (
+	MaxMailAttempts = 10
+	minMailBackoff  = time.Second * 30
+	maxMailBackoff  = time.Hour * 6
+	mailQueuePoll   = time.Minute
+)
+
+/*
+OutgoingMail is a mail waiting to be delivered by the MailQueue.
+*/
+type OutgoingMail struct {
+	Id            kol.Id
+	From          string
+	To            []string
+	Body          []byte
+	Attempts      int
+	NextAttemptAt time.Time
+	LastError     string
+	CreatedAt     time.Time
+}
+
+type OutgoingMails []OutgoingMail
+
+/*
+MailQueue stores outgoing mail in the database and delivers it using a MailTransport, retrying failed deliveries with exponential backoff.
+
+Mail still in the queue when the server stops will be delivered after it restarts.
+*/
+type MailQueue struct {
+	db        *kol.DB
+	logger    gosubs.Logger
+	transport MailTransport
+	wake      chan struct{}
+}
+
+func NewMailQueue(db *kol.DB, logger gosubs.Logger, transport MailTransport) *MailQueue {
+	return &MailQueue{
+		db:        db,
+		logger:    logger,
+		transport: transport,
+		wake:      make(chan struct{}, 1),
+	}
+}
+
+func (self *MailQueue) String() string {
+	return self.transport.String()
+}
+
+func (self *MailQueue) Enqueue(from string, to []string, body []byte) (err error) {
+	now := time.Now()
+	mail := &OutgoingMail{
+		From:          from,
+		To:            to,
+		Body:          body,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	}
+	if err = self.db.Set(mail); err != nil {
+		return
+	}
+	select {
+	case self.wake <- struct{}{}:
+	default:
+	}
+	return
+}
+
+func (self *MailQueue) Start() {
+	go func() {
+		for {
+			select {
+			case <-self.wake:
+			case <-time.After(self.deliver()):
+			}
+		}
+	}()
+}
+
+func mailBackoff(attempts int) (result time.Duration) {
+	result = minMailBackoff
+	for i := 1; i < attempts && result < maxMailBackoff; i++ {
+		result *= 2
+	}
+	if result > maxMailBackoff {
+		result = maxMailBackoff
+	}
+	return
+}
+
+/*
+deliver tries to send all mail due for delivery, and returns how long to wait before the next attempt.
+*/
+func (self *MailQueue) deliver() (wait time.Duration) {
+	wait = mailQueuePoll
+	mails := OutgoingMails{}
+	if err := self.db.Query().All(&mails); err != nil {
+		self.logger.Errorf("Unable to load mail queue: %v", err)
+		return
+	}
+	for index, _ := range mails {
+		mail := &mails[index]
+		now := time.Now()
+		if mail.NextAttemptAt.After(now) {
+			if until := mail.NextAttemptAt.Sub(now); until < wait {
+				wait = until
+			}
+			continue
+		}
+		if err := self.transport.Send(mail.From, mail.To, mail.Body); err != nil {
+			mail.Attempts++
+			mail.LastError = err.Error()
+			if mail.Attempts >= MaxMailAttempts {
+				self.logger.Errorf("Giving up sending %v to %v after %v attempts: %v", mail.Id, mail.To, mail.Attempts, err)
+				if err = self.db.Del(mail); err != nil {
+					self.logger.Errorf("Unable to remove %v from the mail queue: %v", mail.Id, err)
+				}
+				continue
+			}
+			mail.NextAttemptAt = now.Add(mailBackoff(mail.Attempts))
+			self.logger.Errorf("Unable to send %v to %v, will retry at %v: %v", mail.Id, mail.To, mail.NextAttemptAt, err)
+			if err = self.db.Set(mail); err != nil {
+				self.logger.Errorf("Unable to update %v in the mail queue: %v", mail.Id, err)
+			}
+			if until := mail.NextAttemptAt.Sub(now); until < wait {
+				wait = until
+			}
+			continue
+		}
+		self.logger.Infof("Sent %v to %v using %v", mail.Id, mail.To, self.transport)
+		if err := self.db.Del(mail); err != nil {
+			self.logger.Errorf("Unable to remove %v from the mail queue: %v", mail.Id, err)
+		}
+	}
+	return
+}
@@ -0,0 +1,90 @@
+package common
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"net/mail"
+
+	"github.com/jhillyerd/go.enmime"
+	"github.com/zond/gmail"
+)
+
+/*
+MailSource is a source of incoming mail.
+
+Start must not block, and will feed each received mail to handler. Errors that happen after Start returned are given to errorHandler.
+*/
+type MailSource interface {
+	Start(handler func(msg *enmime.MIMEBody) error, errorHandler func(error)) error
+	String() string
+}
+
+func parseMail(r io.Reader) (result *enmime.MIMEBody, err error) {
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return
+	}
+	return enmime.ParseMIMEBody(msg)
+}
+
+/*
+GMailSource receives mail from a GMail account.
+*/
+type GMailSource struct {
+	Account  string
+	Password string
+	client   *gmail.Client
+}
+
+func (self *GMailSource) String() string {
+	return fmt.Sprintf("gmail:%v", self.Account)
+}
+
+func (self *GMailSource) Start(handler func(msg *enmime.MIMEBody) error, errorHandler func(error)) (err error) {
+	self.client = gmail.New(self.Account, self.Password).MailHandler(handler).ErrorHandler(errorHandler)
+	_, err = self.client.Start()
+	return
+}
+
+/*
+WebhookSource receives mail POSTed as raw RFC 822 messages to Receive, for mail providers that forward incoming mail over HTTP.
+
+Requests must provide the configured Token in the token query parameter.
+*/
+type WebhookSource struct {
+	Token   string
+	handler func(msg *enmime.MIMEBody) error
+}
+
+func (self *WebhookSource) String() string {
+	return "webhook"
+}
+
+func (self *WebhookSource) Start(handler func(msg *enmime.MIMEBody) error, errorHandler func(error)) (err error) {
+	if self.Token == "" {
+		err = fmt.Errorf("Refusing to receive mail from a webhook without a token")
+		return
+	}
+	self.handler = handler
+	return
+}
+
+func (self *WebhookSource) Receive(c *HTTPContext) (err error) {
+	if self.handler == nil {
+		c.Resp().WriteHeader(503)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(c.Req().URL.Query().Get("token")), []byte(self.Token)) != 1 {
+		c.Resp().WriteHeader(403)
+		return
+	}
+	msg, err := parseMail(c.Req().Body)
+	if err != nil {
+		c.Resp().WriteHeader(400)
+		fmt.Fprintln(c.Resp(), err)
+		err = nil
+		return
+	}
+	return self.handler(msg)
+}
@@ -0,0 +1,109 @@
+package common
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+/*
+MailTransport delivers rendered mail.
+*/
+type MailTransport interface {
+	Send(from string, to []string, body []byte) error
+	String() string
+}
+
+/*
+SMTPTransport delivers mail to an SMTP server, using STARTTLS when the server supports it and authenticating if Username is set.
+*/
+type SMTPTransport struct {
+	Host            string
+	Username        string
+	Password        string
+	RequireStartTLS bool
+}
+
+func (self *SMTPTransport) String() string {
+	return fmt.Sprintf("smtp:%v", self.Host)
+}
+
+func (self *SMTPTransport) Send(from string, to []string, body []byte) (err error) {
+	host, _, err := net.SplitHostPort(self.Host)
+	if err != nil {
+		return
+	}
+	client, err := smtp.Dial(self.Host)
+	if err != nil {
+		return
+	}
+	defer client.Close()
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err = client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return
+		}
+	} else if self.RequireStartTLS {
+		err = fmt.Errorf("%v does not support STARTTLS", self.Host)
+		return
+	}
+	if self.Username != "" {
+		if err = client.Auth(smtp.PlainAuth("", self.Username, self.Password, host)); err != nil {
+			return
+		}
+	}
+	if err = client.Mail(from); err != nil {
+		return
+	}
+	for _, recip := range to {
+		if err = client.Rcpt(recip); err != nil {
+			return
+		}
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return
+	}
+	if _, err = writer.Write(body); err != nil {
+		return
+	}
+	if err = writer.Close(); err != nil {
+		return
+	}
+	return client.Quit()
+}
+
+var maildirCounter int64
+
+/*
+MaildirTransport delivers mail to a local maildir, so that development servers don't send any real mail.
+*/
+type MaildirTransport struct {
+	Dir string
+}
+
+func (self *MaildirTransport) String() string {
+	return fmt.Sprintf("maildir:%v", self.Dir)
+}
+
+func (self *MaildirTransport) Send(from string, to []string, body []byte) (err error) {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err = os.MkdirAll(filepath.Join(self.Dir, sub), 0700); err != nil {
+			return
+		}
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return
+	}
+	name := fmt.Sprintf("%v.%v_%v.%v", time.Now().Unix(), os.Getpid(), atomic.AddInt64(&maildirCounter, 1), hostname)
+	if err = ioutil.WriteFile(filepath.Join(self.Dir, "tmp", name), body, 0600); err != nil {
+		return
+	}
+	return os.Rename(filepath.Join(self.Dir, "tmp", name), filepath.Join(self.Dir, "new", name))
+}
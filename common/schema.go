@@ -0,0 +1,217 @@
+package common
+
+import (
+	"path"
+	"reflect"
+	"strings"
+	"time"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+/*
+JSONSchema is the subset of JSON Schema needed to describe the payloads of the API.
+*/
+type JSONSchema struct {
+	Ref                  string                 `json:"$ref,omitempty"`
+	Type                 string                 `json:"type,omitempty"`
+	Format               string                 `json:"format,omitempty"`
+	Properties           map[string]*JSONSchema `json:"properties,omitempty"`
+	Items                *JSONSchema            `json:"items,omitempty"`
+	AdditionalProperties *JSONSchema            `json:"additionalProperties,omitempty"`
+}
+
+/*
+OperationSchema describes an operation on a resource.
+
+The payload of Subscribe operations is what the server sends, for other operations it is what the client sends.
+*/
+type OperationSchema struct {
+	Type    string
+	Auth    bool
+	Scope   string
+	Payload *JSONSchema
+}
+
+/*
+ResourceSchema describes a resource, with URI as a regular expression where each group is one of Params.
+*/
+type ResourceSchema struct {
+	Name       string
+	URI        string
+	Params     []string
+	Operations []*OperationSchema
+}
+
+func (self *ResourceSchema) operation(op string) (result *OperationSchema) {
+	for _, result = range self.Operations {
+		if result.Type == op {
+			return
+		}
+	}
+	result = &OperationSchema{
+		Type: op,
+	}
+	self.Operations = append(self.Operations, result)
+	return
+}
+
+type RPCSchema struct {
+	Name     string
+	Auth     bool
+	Scope    string
+	Request  *JSONSchema
+	Response *JSONSchema
+}
+
+/*
+APISchema describes all resources and RPCs of the WebSocket router, with the struct types of their payloads in Definitions.
+*/
+type APISchema struct {
+	Resources   []*ResourceSchema
+	RPCs        []*RPCSchema
+	Definitions map[string]*JSONSchema
+	types       map[string]reflect.Type
+}
+
+func newAPISchema() *APISchema {
+	return &APISchema{
+		Resources:   []*ResourceSchema{},
+		RPCs:        []*RPCSchema{},
+		Definitions: map[string]*JSONSchema{},
+		types:       map[string]reflect.Type{},
+	}
+}
+
+/*
+definitionName returns the name of the definition of t, qualified with its package if another type already uses the plain name.
+*/
+func (self *APISchema) definitionName(t reflect.Type) string {
+	if existing, found := self.types[t.Name()]; !found || existing == t {
+		return t.Name()
+	}
+	return strings.Title(path.Base(t.PkgPath())) + t.Name()
+}
+
+/*
+describe returns the schema of the JSON encoding of v, adding the struct types it contains to the definitions.
+*/
+func (self *APISchema) describe(v interface{}) *JSONSchema {
+	if v == nil {
+		return nil
+	}
+	return self.describeType(reflect.TypeOf(v))
+}
+
+func (self *APISchema) describeType(t reflect.Type) (result *JSONSchema) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	result = &JSONSchema{}
+	switch t.Kind() {
+	case reflect.Bool:
+		result.Type = "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		result.Type = "integer"
+	case reflect.Float32, reflect.Float64:
+		result.Type = "number"
+	case reflect.String:
+		result.Type = "string"
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			// Ids and other byte slices are base64 encoded strings
+			result.Type, result.Format = "string", "byte"
+		} else {
+			result.Type, result.Items = "array", self.describeType(t.Elem())
+		}
+	case reflect.Map:
+		result.Type, result.AdditionalProperties = "object", self.describeType(t.Elem())
+	case reflect.Struct:
+		if t == timeType {
+			result.Type, result.Format = "string", "date-time"
+			return
+		}
+		if t.Name() == "" {
+			return self.describeStruct(t)
+		}
+		name := self.definitionName(t)
+		if _, found := self.types[name]; !found {
+			self.types[name] = t
+			self.Definitions[name] = self.describeStruct(t)
+		}
+		result.Ref = "#/definitions/" + name
+	}
+	return
+}
+
+/*
+describeStruct describes the fields of t the way encoding/json encodes them, with the fields of embedded structs promoted.
+*/
+func (self *APISchema) describeStruct(t reflect.Type) (result *JSONSchema) {
+	result = &JSONSchema{
+		Type:       "object",
+		Properties: map[string]*JSONSchema{},
+	}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		name := field.Name
+		if tag := field.Tag.Get("json"); tag != "" {
+			if tag == "-" {
+				continue
+			}
+			if parts := strings.Split(tag, ","); parts[0] != "" {
+				name = parts[0]
+			}
+		}
+		fieldType := field.Type
+		for fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		switch fieldType.Kind() {
+		case reflect.Chan, reflect.Func, reflect.UnsafePointer:
+			continue
+		}
+		if field.Anonymous && fieldType.Kind() == reflect.Struct && name == field.Name {
+			for embeddedName, embedded := range self.describeStruct(fieldType).Properties {
+				// Fields of the outer struct win over promoted ones
+				if _, found := result.Properties[embeddedName]; !found {
+					result.Properties[embeddedName] = embedded
+				}
+			}
+			continue
+		}
+		result.Properties[name] = self.describeType(field.Type)
+	}
+	return
+}
+
+func (self *APISchema) resource(uri string) (result *ResourceSchema) {
+	result = &ResourceSchema{
+		URI:    uri,
+		Params: []string{},
+	}
+	self.Resources = append(self.Resources, result)
+	return
+}
+
+func (self *APISchema) rpc(name string) (result *RPCSchema) {
+	result = &RPCSchema{
+		Name: name,
+	}
+	self.RPCs = append(self.RPCs, result)
+	return
+}
+
+func (self *Router) Schema() *APISchema {
+	return self.schema
+}
+
+/*
+ServeSchema renders the schema of the router as JSON.
+*/
+func (self *Router) ServeSchema(c *HTTPContext) error {
+	return c.RenderJSON(self.schema)
+}
@@ -6,9 +6,7 @@ import (
 	"fmt"
 	"io"
 	"log"
-	"math/rand"
 	"net/http"
-	"net/smtp"
 	"path/filepath"
 	"strings"
 	"sync/atomic"
@@ -43,24 +41,25 @@ const (
 type Web struct {
 	sessionStore          *sessions.CookieStore
 	db                    *kol.DB
-	gmail                 *gmail.Client
 	env                   string
 	logLevel              int
 	appcache              bool
 	svgTemplates          *template.Template
 	htmlTemplates         *template.Template
 	textTemplates         *template.Template
+	mailTemplates         *template.Template
 	jsModelTemplates      *template.Template
 	jsCollectionTemplates *template.Template
 	jsTemplates           *template.Template
 	cssTemplates          *template.Template
 	_Templates            *template.Template
 	jsViewTemplates       *template.Template
-	gmailAccount          string
-	gmailPassword         string
+	receiveAddress        string
+	mailSources           []MailSource
 	smtpAccount           string
-	smtpHost              string
+	mailQueue             *MailQueue
 	mailHandler           func(c SkinnyContext, msg *enmime.MIMEBody) error
+	apiTokenResolver      APITokenResolver
 	router                *Router
 	secret                string
 }
@@ -76,6 +75,7 @@ func NewWeb(secret, env, db string) (self *Web, err error) {
 		return
 	}
 	self.router = newRouter(self)
+	self.mailQueue = NewMailQueue(self.db, self, nil)
 	self.router.Secret = secret
 	if env != Development {
 		if secret == DefaultSecret {
@@ -94,6 +94,9 @@ func NewWeb(secret, env, db string) (self *Web, err error) {
 	if self.textTemplates, err = templar.GetMatchingTemplates(env == Development, "textTemplates", "^templates/text/[^/]*$"); err != nil {
 		return
 	}
+	if self.mailTemplates, err = templar.GetMatchingTemplates(env == Development, "mailTemplates", "^templates/mail/[^/]*$"); err != nil {
+		return
+	}
 	if self.jsModelTemplates, err = templar.GetMatchingTemplates(env == Development, "jsCollectionTemplates", "^templates/js/models/[^/]*\\.js$"); err != nil {
 		return
 	}
@@ -139,24 +142,56 @@ func (self *Web) SendAddress() string {
 }
 
 func (self *Web) ReceiveAddress() string {
-	return self.gmailAccount
+	return self.receiveAddress
 }
 
 func (self *Web) SetSMTP(host, account string) *Web {
 	self.smtpAccount = account
-	self.smtpHost = host
+	if host != "" {
+		self.SetMailTransport(&SMTPTransport{
+			Host: host,
+		})
+	}
+	return self
+}
+
+func (self *Web) SetMailTransport(transport MailTransport) *Web {
+	self.mailQueue.transport = transport
 	return self
 }
 
+/*
+Start starts delivering outgoing mail and listening to the incoming mail sources.
+
+Only the development env delivers outgoing mail to a maildir, and it does so by default. Other envs refuse to start without a real mail transport.
+*/
 func (self *Web) Start() (err error) {
-	if self.gmailAccount != "" {
-		self.gmail = gmail.New(self.gmailAccount, self.gmailPassword).MailHandler(self.IncomingMail).ErrorHandler(func(e error) {
-			self.Fatalf("Mail handler: %v", e)
-		})
-		if _, err = self.gmail.Start(); err != nil {
+	if self.env == Development {
+		if self.mailQueue.transport == nil {
+			self.mailQueue.transport = &MaildirTransport{
+				Dir: "maildir",
+			}
+		}
+	} else {
+		if self.mailQueue.transport == nil {
+			err = fmt.Errorf("Only development env can run without a mail transport")
 			return
 		}
-		self.Infof("Listening to incoming mail from %#v", self.gmailAccount)
+		if _, isMaildir := self.mailQueue.transport.(*MaildirTransport); isMaildir {
+			err = fmt.Errorf("Only development env can deliver outgoing mail to a maildir")
+			return
+		}
+	}
+	self.mailQueue.Start()
+	self.Infof("Sending outgoing mail using %v", self.mailQueue)
+	for _, source := range self.mailSources {
+		source := source
+		if err = source.Start(self.IncomingMail, func(e error) {
+			self.Errorf("Mail source %v: %v", source, e)
+		}); err != nil {
+			return
+		}
+		self.Infof("Listening to incoming mail from %v", source)
 	}
 	return
 }
@@ -173,35 +208,54 @@ func (self *Web) Diet() SkinnyContext {
 }
 
 func (self *Web) SendMail(fromName, replyTo, subject, message string, recips []string) (err error) {
-	body := strings.Join([]string{
-		"Content-Type: text/plain; charset=\"utf-8\"",
-		fmt.Sprintf("Reply-To: %v", replyTo),
-		fmt.Sprintf("From: %v <%v>", fromName, self.smtpAccount),
-		fmt.Sprintf("To: %v", strings.Join(recips, ", ")),
-		fmt.Sprintf("Subject: %v", subject),
-		"",
-		message,
-	}, "\r\n")
-	actualRecips := []string{}
-	for _, recip := range recips {
-		if match := gmail.AddrReg.FindString(recip); match != "" {
-			actualRecips = append(actualRecips, match)
-		}
+	return self.SendMessage(&Mail{
+		FromName: fromName,
+		ReplyTo:  replyTo,
+		Subject:  subject,
+		Text:     message,
+		To:       recips,
+	})
+}
+
+/*
+RenderMail renders the text and HTML parts of mail from the mail templates name.LANGUAGE.txt and name.LANGUAGE.html,
+falling back to the default language if there are no templates for language.
+*/
+func (self *Web) RenderMail(mail *Mail, name, language string, data interface{}) (err error) {
+	language = translation.GetLanguage(language)
+	if self.mailTemplates.Lookup(fmt.Sprintf("%v.%v.txt", name, language)) == nil {
+		language = translation.GetLanguage("")
 	}
-	if self.Env() == Development {
-		self.Infof("Would have sent\n%v", body)
-	} else {
-		key := uint64(time.Now().UnixNano()<<32) + uint64(rand.Uint32())
-		self.Infof("%v: Will try to send\n%v", key, body)
-		if err = smtp.SendMail(self.smtpHost, nil, self.smtpAccount, actualRecips, []byte(body)); err != nil {
-			self.Errorf("%v: Unable to send: %v", key, err)
+	buf := &bytes.Buffer{}
+	if err = self.mailTemplates.ExecuteTemplate(buf, fmt.Sprintf("%v.%v.txt", name, language), data); err != nil {
+		return
+	}
+	mail.Text = buf.String()
+	if self.mailTemplates.Lookup(fmt.Sprintf("%v.%v.html", name, language)) != nil {
+		buf = &bytes.Buffer{}
+		if err = self.mailTemplates.ExecuteTemplate(buf, fmt.Sprintf("%v.%v.html", name, language), data); err != nil {
 			return
 		}
-		self.Infof("%v: Successfully sent", key)
+		mail.HTML = buf.String()
 	}
 	return
 }
 
+func (self *Web) SendMessage(mail *Mail) (err error) {
+	body, err := mail.Bytes(self.smtpAccount)
+	if err != nil {
+		return
+	}
+	actualRecips := []string{}
+	for _, recip := range mail.To {
+		if match := gmail.AddrReg.FindString(recip); match != "" {
+			actualRecips = append(actualRecips, match)
+		}
+	}
+	self.Infof("Queueing %#v to %v\n%v", mail.Subject, mail.To, mail.Text)
+	return self.mailQueue.Enqueue(self.smtpAccount, actualRecips, body)
+}
+
 func (self *Web) DB() *kol.DB {
 	return self.db
 }
@@ -219,7 +273,25 @@ func (self *Web) GetContext(w http.ResponseWriter, r *http.Request) (result *HTT
 }
 
 func (self *Web) SetGMail(account, password string, handler func(c SkinnyContext, msg *enmime.MIMEBody) error) *Web {
-	self.gmailAccount, self.gmailPassword, self.mailHandler = account, password, handler
+	if account != "" {
+		self.AddMailSource(&GMailSource{
+			Account:  account,
+			Password: password,
+		})
+	}
+	return self.SetMailHandler(account, handler)
+}
+
+/*
+SetMailHandler sets the address incoming mail is sent to, and the handler that all mail sources feed.
+*/
+func (self *Web) SetMailHandler(receiveAddress string, handler func(c SkinnyContext, msg *enmime.MIMEBody) error) *Web {
+	self.receiveAddress, self.mailHandler = receiveAddress, handler
+	return self
+}
+
+func (self *Web) AddMailSource(source MailSource) *Web {
+	self.mailSources = append(self.mailSources, source)
 	return self
 }
 
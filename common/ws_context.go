@@ -79,63 +79,142 @@ func (self *defaultWSContext) SendAddress() string {
 }
 
 func (self *defaultWSContext) ReceiveAddress() string {
-	return self.web.gmailAccount
+	return self.web.receiveAddress
 }
 
 func (self *defaultWSContext) SendMail(fromName, replyTo, subject, message string, recips []string) error {
 	return self.web.SendMail(fromName, replyTo, subject, message, recips)
 }
 
+func (self *defaultWSContext) SendMessage(mail *Mail) error {
+	return self.web.SendMessage(mail)
+}
+
+func (self *defaultWSContext) RenderMail(mail *Mail, name, language string, data interface{}) error {
+	return self.web.RenderMail(mail, name, language, data)
+}
+
 type Router struct {
 	*subs.Router
-	web *Web
+	web    *Web
+	schema *APISchema
 }
 
 func newRouter(web *Web) (result *Router) {
 	result = &Router{
 		Router: subs.NewRouter(web.DB()),
 		web:    web,
+		schema: newAPISchema(),
 	}
 	return
 }
 
 type RPC struct {
 	*gosubs.RPC
+	router *Router
+	schema *RPCSchema
 }
 
 func (self *RPC) Auth() *RPC {
 	self.RPC.Auth()
+	self.schema.Auth = true
+	return self
+}
+
+/*
+Scope lets connections authenticated with API tokens having scope use the RPC.
+*/
+func (self *RPC) Scope(scope string) *RPC {
+	self.schema.Scope = scope
+	return self
+}
+
+/*
+Describe adds the types of the request and response data of the RPC to the schema. Either may be nil.
+*/
+func (self *RPC) Describe(request, response interface{}) *RPC {
+	self.schema.Request = self.router.schema.describe(request)
+	self.schema.Response = self.router.schema.describe(response)
 	return self
 }
 
 type Resource struct {
 	*subs.Resource
-	web *Web
+	router *Router
+	schema *ResourceSchema
+	lastOp string
 }
 
 func (self *Resource) Handle(op string, f func(c WSContext) error) *Resource {
+	operation := self.schema.operation(op)
+	self.lastOp = op
 	self.Resource.Handle(op, func(c subs.Context) error {
-		return f(NewWSContext(c, self.web))
+		scopes, err := self.router.web.connectionScopes(c.Conn().Request())
+		if err != nil {
+			return err
+		}
+		if op != gosubs.SubscribeType && !scopes.allows(operation.Scope) {
+			return fmt.Errorf("Not allowed to %v %v without the %#v scope", op, c.Match()[0], operation.Scope)
+		}
+		return f(NewWSContext(c, self.router.web))
 	})
 	return self
 }
 
 func (self *Resource) Auth() *Resource {
 	self.Resource.Auth()
+	self.schema.operation(self.lastOp).Auth = true
+	return self
+}
+
+/*
+Scope lets connections authenticated with API tokens having scope use op on the resource. Everyone may subscribe.
+*/
+func (self *Resource) Scope(op, scope string) *Resource {
+	self.schema.operation(op).Scope = scope
+	return self
+}
+
+/*
+Name names the resource and the groups of its URI in the schema.
+*/
+func (self *Resource) Name(name string, params ...string) *Resource {
+	self.schema.Name = name
+	self.schema.Params = append([]string{}, params...)
+	return self
+}
+
+/*
+Describe adds the type of the payload of op to the schema.
+*/
+func (self *Resource) Describe(op string, payload interface{}) *Resource {
+	self.schema.operation(op).Payload = self.router.schema.describe(payload)
 	return self
 }
 
 func (self *Router) Resource(s string) *Resource {
 	return &Resource{
 		Resource: self.Router.Resource(s),
-		web:      self.web,
+		router:   self,
+		schema:   self.schema.resource(s),
 	}
 }
 
-func (self *Router) RPC(m string, f func(c WSContext) (result interface{}, err error)) *RPC {
-	return &RPC{
-		RPC: self.Router.RPC(m, func(c subs.Context) (result interface{}, err error) {
-			return f(NewWSContext(c, self.web))
-		}),
+func (self *Router) RPC(m string, f func(c WSContext) (result interface{}, err error)) (result *RPC) {
+	result = &RPC{
+		router: self,
+		schema: self.schema.rpc(m),
 	}
+	result.RPC = self.Router.RPC(m, func(c subs.Context) (res interface{}, err error) {
+		scopes, err := self.web.connectionScopes(c.Conn().Request())
+		if err != nil {
+			return
+		}
+		if !scopes.allows(result.schema.Scope) {
+			err = fmt.Errorf("Not allowed to call %v without the %#v scope", m, result.schema.Scope)
+			return
+		}
+		return f(NewWSContext(c, self.web))
+	})
+	return
 }
@@ -5,8 +5,10 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/zond/diplicity/audit"
 	"github.com/zond/diplicity/common"
 	"github.com/zond/diplicity/epoch"
 	"github.com/zond/diplicity/game"
@@ -29,12 +31,27 @@ func main() {
 	secret := flag.String("secret", common.DefaultSecret, "The cookie store secret")
 	gmailAccount := flag.String("gmail_account", "", "The GMail account to use for sending and receiving message email")
 	gmailPassword := flag.String("gmail_password", "", "The GMail account password")
+	receiveAddress := flag.String("receive_address", "", "The address incoming message email is sent to, if not the GMail account")
+	imapAddr := flag.String("imap_addr", "", "host:port of an IMAP server to poll for incoming message email")
+	imapAccount := flag.String("imap_account", "", "The IMAP account")
+	imapPassword := flag.String("imap_password", "", "The IMAP account password")
+	imapMailbox := flag.String("imap_mailbox", "INBOX", "The IMAP mailbox to poll")
+	imapTLS := flag.Bool("imap_tls", true, "Whether to connect to the IMAP server using TLS")
+	imapInterval := flag.Duration("imap_interval", time.Minute, "How often to poll the IMAP server")
+	mailListenAddr := flag.String("mail_listen_addr", "", "host:port to accept incoming message email on from a local mail server")
+	mailListenSMTP := flag.Bool("mail_listen_smtp", false, "Whether to speak SMTP instead of LMTP on mail_listen_addr")
+	mailWebhookToken := flag.String("mail_webhook_token", "", "If set, accept incoming message email POSTed to /mail/inbound?token=mail_webhook_token")
 	env := flag.String("env", common.Development, "What environment to run")
 	db := flag.String("db", "diplicity", "The path to the database file to use")
 	appcache := flag.Bool("appcache", true, "Whether to enable appcache")
 	logOutput := flag.String("log", "-", "Where to send the log output")
 	smtpAccount := flag.String("smtp_account", "", "What From-address to put in the outgoing email")
-	smtpHost := flag.String("smtp_host", "", "What host to use when sending out email")
+	smtpHost := flag.String("smtp_host", "", "What host:port to use when sending out email")
+	smtpUsername := flag.String("smtp_username", "", "The username to authenticate to smtp_host with, if any")
+	smtpPassword := flag.String("smtp_password", "", "The password to authenticate to smtp_host with")
+	smtpRequireStartTLS := flag.Bool("smtp_require_starttls", false, "Whether to refuse sending email to smtp_host without STARTTLS")
+	maildir := flag.String("maildir", "", "If set, deliver outgoing email to this maildir instead of smtp_host. Only allowed in the development env, where email is delivered to the maildir \"maildir\" by default")
+	devSMTP := flag.Bool("dev_smtp", false, "Whether to really send email through smtp_host in the development env")
 	schedule := flag.Bool("schedule", true, "Schedule unresolved phases at startup")
 	oauthClientSecret := flag.String("oauth_client_secret", "", "The client secret of your OAuth credentials in Google Cloud. See https://developers.google.com/accounts/docs/OpenIDConnect")
 	oauthClientId := flag.String("oauth_client_id", "", "The client id of your OAuth credentials in Google Cloud. See See https://developers.google.com/accounts/docs/OpenIDConnect")
@@ -54,6 +71,49 @@ func main() {
 		panic(err)
 	}
 	server.SetAppcache(*appcache).SetGMail(*gmailAccount, *gmailPassword, game.IncomingMail).SetSMTP(*smtpHost, *smtpAccount)
+	if *maildir != "" {
+		server.SetMailTransport(&common.MaildirTransport{
+			Dir: *maildir,
+		})
+	} else if *env == common.Development && !*devSMTP {
+		server.SetMailTransport(&common.MaildirTransport{
+			Dir: "maildir",
+		})
+	} else if *smtpHost != "" {
+		server.SetMailTransport(&common.SMTPTransport{
+			Host:            *smtpHost,
+			Username:        *smtpUsername,
+			Password:        *smtpPassword,
+			RequireStartTLS: *smtpRequireStartTLS,
+		})
+	}
+	server.SetAPITokenResolver(user.ResolveAPIToken)
+	if *receiveAddress != "" {
+		server.SetMailHandler(*receiveAddress, game.IncomingMail)
+	}
+	if *imapAddr != "" {
+		server.AddMailSource(&common.IMAPSource{
+			Addr:     *imapAddr,
+			Account:  *imapAccount,
+			Password: *imapPassword,
+			Mailbox:  *imapMailbox,
+			TLS:      *imapTLS,
+			Interval: *imapInterval,
+		})
+	}
+	if *mailListenAddr != "" {
+		server.AddMailSource(&common.ListenerSource{
+			Addr: *mailListenAddr,
+			SMTP: *mailListenSMTP,
+		})
+	}
+	var mailWebhook *common.WebhookSource
+	if *mailWebhookToken != "" {
+		mailWebhook = &common.WebhookSource{
+			Token: *mailWebhookToken,
+		}
+		server.AddMailSource(mailWebhook)
+	}
 
 	if *oauthClientSecret == "" {
 		server.Errorf("No oauth_client_secret provided, you will not be able to use Google single sign on")
@@ -72,34 +132,59 @@ func main() {
 
 	// Resource routes for the WebSocket
 	wsRouter := server.Router()
-	wsRouter.Resource("^/games/mine$").
-		Handle(gosubs.SubscribeType, game.SubscribeMine)
-	wsRouter.Resource("^/games/open$").
-		Handle(gosubs.SubscribeType, game.SubscribeOthersOpen)
-	wsRouter.Resource("^/games/closed$").
-		Handle(gosubs.SubscribeType, game.SubscribeOthersClosed)
-	wsRouter.Resource("^/games/finished$").
-		Handle(gosubs.SubscribeType, game.SubscribeOthersFinished)
-	wsRouter.Resource("^/user$").
-		Handle(gosubs.SubscribeType, user.SubscribeEmail).
-		Handle(gosubs.UpdateType, user.Update).Auth()
-	wsRouter.Resource("^/games/(.+)/(\\d+)$").
-		Handle(gosubs.SubscribeType, game.SubscribeGamePhase)
-	wsRouter.Resource("^/games/(.+)/messages$").
-		Handle(gosubs.SubscribeType, game.SubscribeMessages).
-		Handle(gosubs.CreateType, game.CreateMessage).Auth()
-	wsRouter.Resource("^/games/(.+)$").
-		Handle(gosubs.SubscribeType, game.SubscribeGame).
+	wsRouter.Resource("^/games/mine$").Name("GamesMine").
+		Handle(gosubs.SubscribeType, game.SubscribeMine).Describe(gosubs.SubscribeType, game.GameStates{})
+	wsRouter.Resource("^/games/open$").Name("GamesOpen").
+		Handle(gosubs.SubscribeType, game.SubscribeOthersOpen).Describe(gosubs.SubscribeType, game.GameStates{})
+	wsRouter.Resource("^/games/closed$").Name("GamesClosed").
+		Handle(gosubs.SubscribeType, game.SubscribeOthersClosed).Describe(gosubs.SubscribeType, game.GameStates{})
+	wsRouter.Resource("^/games/finished$").Name("GamesFinished").
+		Handle(gosubs.SubscribeType, game.SubscribeOthersFinished).Describe(gosubs.SubscribeType, game.GameStates{})
+	wsRouter.Resource("^/bot/phases$").Name("BotPhases").
+		Handle(gosubs.SubscribeType, game.SubscribeBotPhases).Auth().Describe(gosubs.SubscribeType, game.BotPhases{})
+	wsRouter.Resource("^/user$").Name("User").
+		Handle(gosubs.SubscribeType, user.SubscribeEmail).Describe(gosubs.SubscribeType, user.User{}).
+		Handle(gosubs.UpdateType, user.Update).Auth().Describe(gosubs.UpdateType, user.User{})
+	wsRouter.Resource("^/user/tokens$").Name("APITokens").
+		Handle(gosubs.SubscribeType, user.SubscribeAPITokens).Describe(gosubs.SubscribeType, user.APITokens{}).
+		Handle(gosubs.DeleteType, user.DeleteAPIToken).Auth().Describe(gosubs.DeleteType, user.APITokenRequest{})
+	wsRouter.Resource("^/games/(.+)/(\\d+)$").Name("GamePhase", "GameId", "Ordinal").
+		Handle(gosubs.SubscribeType, game.SubscribeGamePhase).Describe(gosubs.SubscribeType, game.GameState{})
+	wsRouter.Resource("^/games/(.+)/messages$").Name("GameMessages", "GameId").
+		Handle(gosubs.SubscribeType, game.SubscribeMessages).Describe(gosubs.SubscribeType, game.Messages{}).
+		Handle(gosubs.CreateType, game.CreateMessage).Auth().Describe(gosubs.CreateType, game.Message{}).
+		Scope(gosubs.CreateType, common.ScopeMessages)
+	wsRouter.Resource("^/games/(.+)/spectators$").Name("GameSpectators", "GameId").
+		Handle(gosubs.CreateType, game.AddSpectator).Auth().
+		Handle(gosubs.DeleteType, game.DeleteSpectator).Auth()
+	wsRouter.Resource("^/games/(.+)$").Name("Game", "GameId").
+		Handle(gosubs.SubscribeType, game.SubscribeGame).Describe(gosubs.SubscribeType, game.GameState{}).
 		Handle(gosubs.DeleteType, game.DeleteMember).Auth().
-		Handle(gosubs.UpdateType, game.AddMember).Auth()
-	wsRouter.Resource("^/games$").
-		Handle(gosubs.CreateType, game.Create).Auth()
+		Handle(gosubs.UpdateType, game.AddMember).Auth().Describe(gosubs.UpdateType, game.GameState{})
+	wsRouter.Resource("^/games$").Name("Games").
+		Handle(gosubs.CreateType, game.Create).Auth().Describe(gosubs.CreateType, game.GameState{})
 
 	// RPC routes for the WebSocket
-	wsRouter.RPC("SetOrder", game.SetOrder).Auth()
-	wsRouter.RPC("Commit", game.CommitPhase).Auth()
-	wsRouter.RPC("Uncommit", game.UncommitPhase).Auth()
-	wsRouter.RPC("See", game.SeeMessage).Auth()
+	wsRouter.RPC("SetOrder", game.SetOrder).Auth().Scope(common.ScopeOrders).Describe(game.OrderRequest{}, nil)
+	wsRouter.RPC("Commit", game.CommitPhase).Auth().Scope(common.ScopeOrders).Describe(game.CommitRequest{}, nil)
+	wsRouter.RPC("Uncommit", game.UncommitPhase).Auth().Scope(common.ScopeOrders).Describe(game.CommitRequest{}, nil)
+	wsRouter.RPC("See", game.SeeMessage).Auth().Scope(common.ScopeMessages).Describe(game.SeeRequest{}, nil)
+	wsRouter.RPC("StartWithBots", game.StartWithBots).Auth().Describe(game.StartRequest{}, nil)
+	wsRouter.RPC("PracticeRollback", game.PracticeRollback).Auth().Describe(game.RollbackRequest{}, nil)
+	wsRouter.RPC("PracticeRestore", game.PracticeRestore).Auth().Describe(game.RestoreRequest{}, nil)
+	wsRouter.RPC("VotePause", game.VotePause).Auth().Describe(game.VoteRequest{}, nil)
+	wsRouter.RPC("VoteResume", game.VoteResume).Auth().Describe(game.VoteRequest{}, nil)
+	wsRouter.RPC("VoteExtension", game.VoteExtension).Auth().Describe(game.VoteRequest{}, nil)
+	wsRouter.RPC("VoteMute", game.VoteMute).Auth().Describe(game.MuteRequest{}, nil)
+	wsRouter.RPC("ReportMessage", game.ReportMessage).Auth().Scope(common.ScopeMessages).Describe(game.ReportRequest{}, nil)
+	wsRouter.RPC("GetMessages", game.GetMessages).Scope(common.ScopeRead).Describe(game.MessagesRequest{}, game.Messages{})
+	wsRouter.RPC("SearchMessages", game.SearchMessages).Scope(common.ScopeRead).Describe(game.MessagesRequest{}, game.Messages{})
+	wsRouter.RPC("GetCalendarURL", game.GetCalendarURL).Auth().Describe(nil, "")
+	wsRouter.RPC("CreateWebhook", game.CreateWebhook).Auth().Describe(game.WebhookRequest{}, game.Webhook{})
+	wsRouter.RPC("DeleteWebhook", game.DeleteWebhook).Auth().Describe(game.WebhookRequest{}, nil)
+	wsRouter.RPC("GetWebhooks", game.GetWebhooks).Auth().Describe(nil, game.Webhooks{})
+	wsRouter.RPC("GetWebhookDeliveries", game.GetWebhookDeliveries).Auth().Describe(game.WebhookRequest{}, game.WebhookDeliveries{})
+	wsRouter.RPC("CreateAPIToken", user.CreateAPIToken).Auth().Describe(user.APITokenRequest{}, user.CreatedAPIToken{})
 
 	// The websocket
 	router.Path("/ws").Handler(wsRouter)
@@ -114,18 +199,45 @@ func main() {
 
 	// Admin
 	server.AdminHandle(router.Path("/admin/games/{game_id}/rollback/{until}").Methods("POST"), game.AdminRollback)
+	server.AdminHandle(router.Path("/admin/games/{game_id}/rollbacks").Methods("GET"), game.AdminGetRollbacks)
+	server.AdminHandle(router.Path("/admin/rollbacks/{rollback_id}/restore").Methods("POST"), game.AdminRestoreRollback)
 	server.AdminHandle(router.Path("/admin/games/{game_id}").Methods("GET"), game.AdminGetGame)
+	server.AdminHandle(router.Path("/admin/reports").Methods("GET"), game.AdminGetReports)
 	server.AdminHandle(router.Path("/admin/games/{game_id}/nations/{nation}/options").Methods("GET"), game.AdminGetOptions)
 	server.AdminHandle(router.Path("/admin/users").Methods("POST"), user.AdminCreateUser)
 	server.AdminHandle(router.Path("/admin/games/{game_id}/recalc").Methods("POST"), game.AdminRecalcOptions)
 	server.AdminHandle(router.Path("/admin/games/reindex").Methods("POST"), game.AdminReindexGames)
+	server.AdminHandle(router.Path("/admin/messages/reindex").Methods("POST"), game.AdminReindexMessages)
 	server.AdminHandle(router.Path("/admin/users/setrank1").Methods("POST"), user.AdminSetRank1)
+	server.AdminHandle(router.Path("/admin/audit").Methods("GET"), audit.AdminGetEntries)
 	server.DevHandle(router.Path("/admin/become").Methods("POST"), user.AdminBecome)
 
 	server.Handle(router.Path("/resolve/{variant}").Methods("POST"), game.Resolve)
 
 	// Unsubscribe
 	server.Handle(router.Path("/unsubscribe/{unsubscribe_tag}").Methods("GET"), game.UnsubscribeEmails)
+	server.Handle(router.Path("/calendar/{calendar_tag}").Methods("GET"), game.Calendar)
+	if mailWebhook != nil {
+		server.Handle(router.Path("/mail/inbound").Methods("POST"), mailWebhook.Receive)
+	}
+
+	// Versioned REST API, serving the same handlers as the WebSocket
+	api := router.PathPrefix("/api/v1").Subrouter()
+	server.Handle(api.Path("/schema").Methods("GET"), wsRouter.ServeSchema)
+	server.HandleAPI(api, "GET", "/games/mine", game.GetMine).Auth().Scope(common.ScopeRead)
+	server.HandleAPI(api, "POST", "/games", common.APIFunc(game.Create)).Auth()
+	server.HandleAPI(api, "GET", "/games/{GameId}", game.GetGame).Scope(common.ScopeRead)
+	server.HandleAPI(api, "POST", "/games/{GameId}/orders", game.SetOrder).Auth().Scope(common.ScopeOrders)
+	server.HandleAPI(api, "POST", "/games/{GameId}/commit", game.CommitPhase).Auth().Scope(common.ScopeOrders)
+	server.HandleAPI(api, "POST", "/games/{GameId}/uncommit", game.UncommitPhase).Auth().Scope(common.ScopeOrders)
+	server.HandleAPI(api, "GET", "/games/{GameId}/messages", game.GetMessages).Scope(common.ScopeRead)
+	server.HandleAPI(api, "POST", "/games/{GameId}/messages", common.APIFunc(game.CreateMessage)).Auth().Scope(common.ScopeMessages)
+	server.HandleAPI(api, "GET", "/games/{GameId}/messages/search", game.SearchMessages).Scope(common.ScopeRead)
+	server.HandleAPI(api, "GET", "/user", user.Get).Auth().Scope(common.ScopeRead)
+	server.HandleAPI(api, "PUT", "/user", common.APIFunc(user.Update)).Auth()
+	server.HandleAPI(api, "GET", "/user/tokens", user.GetAPITokens).Auth().Scope(common.ScopeRead)
+	server.HandleAPI(api, "POST", "/user/tokens", user.CreateAPIToken).Auth()
+	server.HandleAPI(api, "DELETE", "/user/tokens/{Id}", common.APIFunc(user.DeleteAPIToken)).Auth()
 
 	// Everything else HTMLy
 	server.Handle(router.MatcherFunc(wantsHTML), server.Index)
@@ -142,8 +254,13 @@ func main() {
 		if err := game.ScheduleUnresolvedPhases(server.Diet()); err != nil {
 			panic(err)
 		}
+		if err := game.ScheduleUnstartedGames(server.Diet()); err != nil {
+			panic(err)
+		}
+		game.StartDigests(server.Diet())
+		game.StartWebhooks(server.Diet())
 	}
-	server.Infof("Listening to %v (env=%#v, appcache=%#v, gmail_account=%#v, smtp_account=%#v, smtp_host=%#v)", addr, *env, *appcache, *gmailAccount, *smtpAccount, *smtpHost)
+	server.Infof("Listening to %v (env=%#v, appcache=%#v, receive_address=%#v, smtp_account=%#v, smtp_host=%#v)", addr, *env, *appcache, server.ReceiveAddress(), *smtpAccount, *smtpHost)
 	server.Fatalf("%v", http.ListenAndServe(addr, router))
 
 }
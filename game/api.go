@@ -0,0 +1,48 @@
+package game
+
+import (
+	"encoding/base64"
+
+	"github.com/zond/diplicity/common"
+	"github.com/zond/kcwraps/kol"
+	"github.com/zond/wsubs/gosubs"
+)
+
+/*
+GetMine returns the states of the games of the caller, the most urgent first, like the /games/mine subscription.
+*/
+func GetMine(c common.WSContext) (result interface{}, err error) {
+	members := Members{}
+	if err = c.DB().Query().Where(kol.Equals{"UserId", kol.Id(c.Principal())}).All(&members); err != nil {
+		return
+	}
+	pointers := make([]*Member, len(members))
+	for index, _ := range members {
+		pointers[index] = &members[index]
+	}
+	return memberGameStates(c, pointers, gosubs.FetchType)
+}
+
+/*
+GetGame returns the state of the game with GameId, like the /games/{id} subscription.
+*/
+func GetGame(c common.WSContext) (result interface{}, err error) {
+	gameId, err := base64.URLEncoding.DecodeString(c.Data().GetString("GameId"))
+	if err != nil {
+		return
+	}
+	game := &Game{Id: gameId}
+	if err = c.DB().Get(game); err != nil {
+		return
+	}
+	state, visible, err := visibleGameState(c, game)
+	if err != nil {
+		return
+	}
+	if !visible {
+		err = kol.NotFound
+		return
+	}
+	result = state
+	return
+}
@@ -0,0 +1,298 @@
+package game
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+
+	"github.com/zond/diplicity/common"
+	cla "github.com/zond/godip/classical/common"
+	"github.com/zond/godip/classical/orders"
+	dip "github.com/zond/godip/common"
+)
+
+const (
+	BotRandom    = "random"
+	BotHeuristic = "heuristic"
+)
+
+/*
+botPlayer returns the orders it wants to give, most wanted first, given all the orders it could give for each province.
+Orders that turn out to be invalid, or give orders to the same unit twice, are skipped.
+*/
+type botPlayer func(variant *common.Variant, phase *Phase, nation dip.Nation, candidates map[dip.Province][][]string) [][]string
+
+var botPlayers = map[string]botPlayer{
+	BotRandom:    randomBot,
+	BotHeuristic: heuristicBot,
+}
+
+var botNames = map[string]string{
+	BotRandom:    "Random bot",
+	BotHeuristic: "Heuristic bot",
+}
+
+/*
+botCandidates returns all complete orders in opts, by the province the unit is in.
+
+The orders are built the way the client builds them when walking the options: source province, then order and unit types
+and any further provinces in the order they are chosen.
+*/
+func botCandidates(opts dip.Options) (result map[dip.Province][][]string) {
+	result = map[dip.Province][][]string{}
+	for key, next := range opts {
+		if prov, ok := key.(dip.Province); ok {
+			collectBotCandidates(next, string(prov), []string{}, func(order []string) {
+				result[prov] = append(result[prov], order)
+			})
+		}
+	}
+	return
+}
+
+func collectBotCandidates(opts dip.Options, src string, order []string, f func(order []string)) {
+	if len(opts) == 0 {
+		f(append([]string{src}, order...))
+		return
+	}
+	for key, next := range opts {
+		switch value := key.(type) {
+		case dip.SrcProvince:
+			collectBotCandidates(next, string(value), order, f)
+		case dip.Province:
+			collectBotCandidates(next, src, append(append([]string{}, order...), string(value)), f)
+		case dip.OrderType:
+			collectBotCandidates(next, src, append(append([]string{}, order...), string(value)), f)
+		case dip.UnitType:
+			collectBotCandidates(next, src, append(append([]string{}, order...), string(value)), f)
+		}
+	}
+}
+
+/*
+randomBot gives a random order to each unit.
+*/
+func randomBot(variant *common.Variant, phase *Phase, nation dip.Nation, candidates map[dip.Province][][]string) (result [][]string) {
+	all := [][]string{}
+	for _, provOrders := range candidates {
+		all = append(all, provOrders...)
+	}
+	result = make([][]string, len(all))
+	for i, j := range rand.Perm(len(all)) {
+		result[i] = all[j]
+	}
+	return
+}
+
+type scoredOrder struct {
+	order []string
+	score float64
+}
+
+type scoredOrders []scoredOrder
+
+func (self scoredOrders) Len() int {
+	return len(self)
+}
+
+func (self scoredOrders) Less(i, j int) bool {
+	return self[i].score > self[j].score
+}
+
+func (self scoredOrders) Swap(i, j int) {
+	self[i], self[j] = self[j], self[i]
+}
+
+/*
+heuristicBot moves towards supply centers it doesn't own, neutral ones first, keeps units in its home centers,
+retreats towards supply centers and builds where it can.
+*/
+func heuristicBot(variant *common.Variant, phase *Phase, nation dip.Nation, candidates map[dip.Province][][]string) (result [][]string) {
+	isSC := func(prov dip.Province) bool {
+		return variant.Graph.SC(prov.Super()) != nil
+	}
+	occupied := map[dip.Province]bool{}
+	for prov, unit := range phase.Units {
+		if unit.Nation == nation {
+			occupied[prov.Super()] = true
+		}
+	}
+	// Home centers without our units need defending
+	undefended := map[dip.Province]bool{}
+	for prov, owner := range variant.SupplyCenters {
+		if owner == nation && phase.SupplyCenters[prov] == nation && !occupied[prov] {
+			undefended[prov] = true
+		}
+	}
+	scored := scoredOrders{}
+	for _, provOrders := range candidates {
+		for _, order := range provOrders {
+			// Random noise to break ties and vary the play
+			score := rand.Float64()
+			src := dip.Province(order[0]).Super()
+			switch dip.OrderType(order[1]) {
+			case cla.Hold:
+				score += 1
+				if variant.SupplyCenters[src] == nation {
+					score += 4
+				}
+			case cla.Move, cla.MoveViaConvoy:
+				dst := dip.Province(order[len(order)-1]).Super()
+				if isSC(dst) {
+					if owner, found := phase.SupplyCenters[dst]; !found {
+						score += 10
+					} else if owner != nation {
+						score += 6
+					}
+				}
+				if undefended[dst] {
+					score += 5
+				}
+				if variant.SupplyCenters[src] == nation && phase.Season == cla.Fall {
+					// Leaving a home center in the fall invites others in
+					score -= 3
+				}
+			case cla.Support:
+				score += 0.5
+			case cla.Build:
+				score += 5
+			case cla.Disband:
+				if !isSC(src) {
+					score += 1
+				}
+			}
+			scored = append(scored, scoredOrder{
+				order: order,
+				score: score,
+			})
+		}
+	}
+	sort.Sort(scored)
+	claimed := map[dip.Province]bool{}
+	for _, candidate := range scored {
+		orderType := dip.OrderType(candidate.order[1])
+		if orderType == cla.Move || orderType == cla.MoveViaConvoy {
+			// Don't bounce our own units
+			dst := dip.Province(candidate.order[len(candidate.order)-1]).Super()
+			if claimed[dst] {
+				continue
+			}
+			claimed[dst] = true
+		}
+		result = append(result, candidate.order)
+	}
+	return
+}
+
+/*
+playBots gives orders for, and commits, all bot and autopilot members of the game with something to do in phase. The caller saves phase.
+*/
+func (self *Game) playBots(c common.SkinnyContext, phase *Phase, members Members) (err error) {
+	variant, found := common.VariantMap[self.Variant]
+	if !found {
+		err = fmt.Errorf("Unknown variant %v", self.Variant)
+		return
+	}
+	for index, _ := range members {
+		member := &members[index]
+		kind := member.Bot
+		if kind == "" && member.Autopilot {
+			kind = BotHeuristic
+		}
+		player, found := botPlayers[kind]
+		if !found || member.NoOrders || member.Committed {
+			continue
+		}
+		if err = phase.giveBotOrders(variant, member.Nation, player); err != nil {
+			return
+		}
+		member.Committed = true
+		if err = c.DB().Set(member); err != nil {
+			return
+		}
+		c.Infof("%v bot gave %v orders for %v in %v", kind, len(phase.Orders[member.Nation]), member.Nation, self.Id)
+	}
+	return
+}
+
+/*
+adjustments returns how many builds, or disbands, nation has to give in an adjustment phase.
+*/
+func (self *Phase) adjustments(nation dip.Nation) (result int) {
+	for _, owner := range self.SupplyCenters {
+		if owner == nation {
+			result++
+		}
+	}
+	for _, unit := range self.Units {
+		if unit.Nation == nation {
+			result--
+		}
+	}
+	if result < 0 {
+		result = -result
+	}
+	return
+}
+
+/*
+giveBotOrders lets player give the orders of nation in the phase, validating them like SetOrder does.
+*/
+func (self *Phase) giveBotOrders(variant *common.Variant, nation dip.Nation, player botPlayer) (err error) {
+	opts, err := self.Options(nation)
+	if err != nil {
+		return
+	}
+	if self.Orders == nil {
+		self.Orders = map[dip.Nation]map[dip.Province][]string{}
+	}
+	nationOrders := map[dip.Province][]string{}
+	self.Orders[nation] = nationOrders
+	state, err := self.State()
+	if err != nil {
+		return
+	}
+	limit := -1
+	if self.Type == cla.Adjustment {
+		limit = self.adjustments(nation)
+	}
+	for _, order := range player(variant, self, nation, botCandidates(opts)) {
+		if limit >= 0 && len(nationOrders) >= limit {
+			break
+		}
+		prov := dip.Province(order[0])
+		if _, found := nationOrders[prov]; found {
+			continue
+		}
+		parsed, parseErr := orders.Parse(order)
+		if parseErr != nil || parsed.Validate(state) != nil {
+			continue
+		}
+		nationOrders[prov] = order[1:]
+		state.SetOrder(prov, parsed)
+	}
+	return
+}
+
+/*
+fillWithBots adds members of kind to all seats not taken by the members of the game.
+*/
+func (self *Game) fillWithBots(c common.SkinnyContext, kind string) (err error) {
+	if _, found := botPlayers[kind]; !found {
+		err = fmt.Errorf("Unknown bot %#v", kind)
+		return
+	}
+	members, err := self.Members(c.DB())
+	if err != nil {
+		return
+	}
+	for i := len(members); i < len(common.VariantMap[self.Variant].Nations); i++ {
+		if err = c.DB().Set(&Member{
+			GameId: self.Id,
+			Bot:    kind,
+		}); err != nil {
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,89 @@
+package game
+
+import (
+	"time"
+
+	"github.com/zond/diplicity/common"
+	"github.com/zond/diplicity/epoch"
+	dip "github.com/zond/godip/common"
+	"github.com/zond/kcwraps/kol"
+	"github.com/zond/wsubs/gosubs"
+)
+
+/*
+BotPhase is everything an external bot needs to give the orders of one of its members: the current phase,
+with the orders the member has given so far, and the options of the member.
+*/
+type BotPhase struct {
+	GameId   kol.Id
+	MemberId kol.Id
+	Nation   dip.Nation
+	Phase    *Phase
+	Options  interface{}
+	TimeLeft time.Duration
+}
+
+type BotPhases []BotPhase
+
+/*
+botPhases returns the phases members have to give orders in.
+*/
+func botPhases(c common.WSContext, members []*Member) (result BotPhases, err error) {
+	ep, err := epoch.Get(c.DB())
+	if err != nil {
+		return
+	}
+	result = BotPhases{}
+	for _, member := range members {
+		if member.Committed || member.NoOrders {
+			continue
+		}
+		game := &Game{Id: member.GameId}
+		if err = c.DB().Get(game); err != nil {
+			return
+		}
+		if game.State != common.GameStateStarted || game.Paused {
+			continue
+		}
+		var phase *Phase
+		if _, phase, err = game.Phase(c.DB(), 0); err != nil {
+			return
+		}
+		if phase == nil || phase.Resolved {
+			continue
+		}
+		result = append(result, BotPhase{
+			GameId:   game.Id,
+			MemberId: member.Id,
+			Nation:   member.Nation,
+			Phase:    phase.redact(member.Nation),
+			Options:  member.Options,
+			TimeLeft: phase.Deadline - ep,
+		})
+	}
+	return
+}
+
+/*
+SubscribeBotPhases sends the phases the caller has to give orders in, and then every phase it gets orders to give in.
+
+The same phase is sent again when something else about the member changes, so bots have to ignore phases they already play.
+*/
+func SubscribeBotPhases(c common.WSContext) error {
+	s := c.Pack().New(c.Match()[0])
+	s.Query = s.DB().Query().Where(kol.Equals{"UserId", kol.Id(c.Principal())})
+	s.Call = func(i interface{}, op string) (err error) {
+		if op == gosubs.DeleteType {
+			return
+		}
+		var phases BotPhases
+		if phases, err = botPhases(c, i.([]*Member)); err != nil {
+			return
+		}
+		if op == gosubs.FetchType || len(phases) > 0 {
+			return s.Send(phases, op)
+		}
+		return
+	}
+	return s.Subscribe(&Member{})
+}
@@ -0,0 +1,136 @@
+package game
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/zond/diplicity/common"
+	"github.com/zond/diplicity/epoch"
+	"github.com/zond/diplicity/user"
+	"github.com/zond/kcwraps/kol"
+)
+
+const (
+	icalTimeFormat = "20060102T150405Z"
+)
+
+var icalEscaper = strings.NewReplacer("\\", "\\\\", ";", "\\;", ",", "\\,", "\n", "\\n")
+
+/*
+writeICalLine writes a content line, folded at 75 octets as RFC 5545 wants.
+*/
+func writeICalLine(buf *bytes.Buffer, line string) {
+	limit := 75
+	for len(line) > limit {
+		cut := limit
+		// Don't split UTF-8 sequences
+		for cut > 0 && line[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		buf.WriteString(line[:cut])
+		buf.WriteString("\r\n ")
+		line = line[cut:]
+		// Continuation lines start with a space
+		limit = 74
+	}
+	buf.WriteString(line)
+	buf.WriteString("\r\n")
+}
+
+func calendarURL(c common.SkinnyContext, u *user.User) (result string, err error) {
+	tag := &common.CalendarTag{
+		U: u.Id,
+	}
+	tag.H = tag.Hash(c.Secret())
+	encoded, err := tag.Encode()
+	if err != nil {
+		return
+	}
+	result = fmt.Sprintf("http://%v/calendar/%v", u.DiplicityHost, encoded)
+	return
+}
+
+/*
+GetCalendarURL returns the secret URL of the iCalendar feed of the deadlines of the caller.
+*/
+func GetCalendarURL(c common.WSContext) (result interface{}, err error) {
+	u := &user.User{Id: kol.Id(c.Principal())}
+	if err = c.DB().Get(u); err != nil {
+		return
+	}
+	return calendarURL(c.Diet(), u)
+}
+
+/*
+Calendar serves an iCalendar feed with the current deadline of each running, unpaused, game of the user in the calendar tag.
+*/
+func Calendar(c *common.HTTPContext) (err error) {
+	tag, err := common.DecodeCalendarTag(c.Secret(), c.Vars()["calendar_tag"])
+	if err != nil {
+		c.Resp().WriteHeader(403)
+		err = nil
+		return
+	}
+	u := &user.User{Id: tag.U}
+	if err = c.DB().Get(u); err != nil {
+		return
+	}
+	members := Members{}
+	if err = c.DB().Query().Where(kol.Equals{"UserId", u.Id}).All(&members); err != nil {
+		return
+	}
+	ep, err := epoch.Get(c.DB())
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	buf := &bytes.Buffer{}
+	writeICalLine(buf, "BEGIN:VCALENDAR")
+	writeICalLine(buf, "VERSION:2.0")
+	writeICalLine(buf, "PRODID:-//diplicity//deadlines//EN")
+	writeICalLine(buf, "X-WR-CALNAME:Diplicity")
+	// Owners of hot seat practice games are several members of the same game
+	seen := map[string]bool{}
+	for _, member := range members {
+		if seen[member.GameId.String()] {
+			continue
+		}
+		seen[member.GameId.String()] = true
+		game := &Game{Id: member.GameId}
+		if err = c.DB().Get(game); err != nil {
+			return
+		}
+		if game.State != common.GameStateStarted || game.Paused {
+			continue
+		}
+		var phase *Phase
+		if _, phase, err = game.Phase(c.DB(), 0); err != nil {
+			return
+		}
+		if phase == nil || phase.Resolved || phase.Deadline == 0 {
+			continue
+		}
+		var description string
+		if description, err = game.Describe(c.Diet(), u); err != nil {
+			return
+		}
+		// Deadlines are in server time, so convert them to wall clock time using the current epoch
+		deadline := now.Add(phase.Deadline - ep).UTC()
+		link := fmt.Sprintf("http://%v/games/%v", u.DiplicityHost, game.Id)
+		writeICalLine(buf, "BEGIN:VEVENT")
+		writeICalLine(buf, fmt.Sprintf("UID:%v@diplicity", phase.Id))
+		writeICalLine(buf, fmt.Sprintf("DTSTAMP:%v", now.UTC().Format(icalTimeFormat)))
+		writeICalLine(buf, fmt.Sprintf("DTSTART:%v", deadline.Format(icalTimeFormat)))
+		writeICalLine(buf, fmt.Sprintf("DTEND:%v", deadline.Format(icalTimeFormat)))
+		writeICalLine(buf, fmt.Sprintf("SUMMARY:%v", icalEscaper.Replace(fmt.Sprintf("Diplicity: %v %v", member.Nation, description))))
+		writeICalLine(buf, fmt.Sprintf("URL:%v", link))
+		writeICalLine(buf, fmt.Sprintf("DESCRIPTION:%v", icalEscaper.Replace(link)))
+		writeICalLine(buf, "END:VEVENT")
+	}
+	writeICalLine(buf, "END:VCALENDAR")
+	c.SetContentType("text/calendar; charset=UTF-8", false)
+	_, err = c.Resp().Write(buf.Bytes())
+	return
+}
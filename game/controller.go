@@ -4,11 +4,13 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
 	"sort"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/zond/diplicity/audit"
 	"github.com/zond/diplicity/common"
 	"github.com/zond/diplicity/epoch"
 	"github.com/zond/diplicity/user"
@@ -104,6 +106,9 @@ func AdminGetOptions(c *common.HTTPContext) (err error) {
 }
 
 func AdminReindexGames(c *common.HTTPContext) (err error) {
+	defer func() {
+		audit.Record(c, common.Admin, "ReindexGames", "", nil, err)
+	}()
 	games := Games{}
 	if err = c.DB().Query().All(&games); err != nil {
 		return
@@ -117,7 +122,31 @@ func AdminReindexGames(c *common.HTTPContext) (err error) {
 	return
 }
 
+func AdminReindexMessages(c *common.HTTPContext) (err error) {
+	defer func() {
+		audit.Record(c, common.Admin, "ReindexMessages", "", nil, err)
+	}()
+	messages := Messages{}
+	if err = c.DB().Query().All(&messages); err != nil {
+		return
+	}
+	for index, _ := range messages {
+		message := &messages[index]
+		if message.ChannelKey == "" {
+			message.ChannelKey = ChannelKey(message.GameId, message.ChannelId())
+			if err = c.DB().Set(message); err != nil {
+				return
+			}
+		}
+		fmt.Fprintf(c.Resp(), "Reindexed %#v\n", message.Id.String())
+	}
+	return
+}
+
 func AdminRecalcOptions(c *common.HTTPContext) (err error) {
+	defer func() {
+		audit.Record(c, common.Admin, "RecalcOptions", c.Vars()["game_id"], nil, err)
+	}()
 	gameId, err := base64.URLEncoding.DecodeString(c.Vars()["game_id"])
 	if err != nil {
 		return
@@ -154,12 +183,40 @@ func AdminRecalcOptions(c *common.HTTPContext) (err error) {
 	return
 }
 
+type AdminRollbackRequest struct {
+	Reason string
+}
+
 func AdminRollback(c *common.HTTPContext) (err error) {
+	req := AdminRollbackRequest{}
+	defer func() {
+		audit.Record(c, common.Admin, "Rollback", c.Vars()["game_id"], map[string]string{
+			"Until":  c.Vars()["until"],
+			"Reason": req.Reason,
+		}, err)
+	}()
 	gameId, err := base64.URLEncoding.DecodeString(c.Vars()["game_id"])
 	if err != nil {
 		return
 	}
-	epoch, err := epoch.Get(c.DB())
+	ordinal, err := strconv.Atoi(c.Vars()["until"])
+	if err != nil {
+		return
+	}
+	if err = json.NewDecoder(c.Req().Body).Decode(&req); err != nil && err != io.EOF {
+		return
+	}
+	return c.Diet().Transact(func(c common.SkinnyContext) (err error) {
+		g := &Game{Id: gameId}
+		if err = c.DB().Get(g); err != nil {
+			return
+		}
+		return g.rollback(c, ordinal, kol.Id(common.Admin), req.Reason)
+	})
+}
+
+func AdminGetRollbacks(c *common.HTTPContext) (err error) {
+	gameId, err := base64.URLEncoding.DecodeString(c.Vars()["game_id"])
 	if err != nil {
 		return
 	}
@@ -167,52 +224,54 @@ func AdminRollback(c *common.HTTPContext) (err error) {
 	if err = c.DB().Get(g); err != nil {
 		return
 	}
-	ordinal, err := strconv.Atoi(c.Vars()["until"])
+	rollbacks, err := g.Rollbacks(c.DB())
 	if err != nil {
 		return
 	}
-	members, err := g.Members(c.DB())
+	return c.RenderJSON(rollbacks)
+}
+
+func AdminRestoreRollback(c *common.HTTPContext) (err error) {
+	defer func() {
+		audit.Record(c, common.Admin, "RestoreRollback", c.Vars()["rollback_id"], nil, err)
+	}()
+	rollbackId, err := base64.URLEncoding.DecodeString(c.Vars()["rollback_id"])
 	if err != nil {
 		return
 	}
-	phases, err := g.Phases(c.DB())
-	if err != nil {
+	return c.Diet().Transact(func(c common.SkinnyContext) (err error) {
+		rollback := &Rollback{Id: rollbackId}
+		if err = c.DB().Get(rollback); err != nil {
+			return
+		}
+		g := &Game{Id: rollback.GameId}
+		if err = c.DB().Get(g); err != nil {
+			return
+		}
+		return g.restore(c, rollback)
+	})
+}
+
+func AdminGetReports(c *common.HTTPContext) (err error) {
+	reports := Reports{}
+	if err = c.DB().Query().All(&reports); err != nil {
 		return
 	}
-	sort.Sort(phases)
-	for index, _ := range phases {
-		phase := &phases[index]
-		if phase.Ordinal == ordinal {
-			phase.Resolutions = map[dip.Province]string{}
-			phase.Resolved = false
-			phase.Deadline = epoch + (time.Minute * time.Duration(g.Deadlines[phase.Type]))
-			for index, _ := range members {
-				opts := dip.Options{}
-				if opts, err = phase.Options(members[index].Nation); err != nil {
-					return
-				}
-				members[index].Options = opts
-				if len(opts) == 0 {
-					members[index].Committed = true
-					members[index].NoOrders = true
-				} else {
-					members[index].Committed = false
-					members[index].NoOrders = false
-				}
-				if err = c.DB().Set(&members[index]); err != nil {
-					return
-				}
-			}
-			if err = c.DB().Set(phase); err != nil {
-				return
-			}
-		} else if phase.Ordinal > ordinal {
-			if err = c.DB().Del(phase); err != nil {
-				return
-			}
+	sort.Sort(reports)
+	result := []ReportState{}
+	for index, _ := range reports {
+		message := &Message{Id: reports[index].MessageId}
+		if err = c.DB().Get(message); err == kol.NotFound {
+			message, err = nil, nil
+		} else if err != nil {
+			return
 		}
+		result = append(result, ReportState{
+			Report:  &reports[index],
+			Message: message,
+		})
 	}
-	return
+	return c.RenderJSON(result)
 }
 
 type AdminGameState struct {
@@ -258,8 +317,16 @@ func CreateMessage(c common.WSContext) (err error) {
 		message.RecipientIds = map[string]bool{}
 	}
 
-	// set the body
+	// set the body, describing the press if there is no text
 	message.Body = strings.TrimSpace(message.Body)
+	if message.Press != nil {
+		if err = message.Press.validate(); err != nil {
+			return
+		}
+		if message.Body == "" {
+			message.Body = message.Press.String()
+		}
+	}
 	if message.Body == "" {
 		return
 	}
@@ -275,9 +342,83 @@ func CreateMessage(c common.WSContext) (err error) {
 		return
 	}
 
+	if message.SpectatorChat {
+		if sender == nil {
+			var spectator *Spectator
+			if spectator, err = game.Spectator(c.DB(), c.Principal()); err != nil {
+				return
+			}
+			if spectator == nil {
+				err = fmt.Errorf("Not spectator of game")
+				return
+			}
+			return message.SendToSpectators(c.Diet(), game, spectator.Id)
+		}
+		if game.State != common.GameStateEnded {
+			err = IllegalMessageError{
+				Description: fmt.Sprintf("%+v does not allow members to send %+v before it has ended", game, message),
+				Phrase:      "This kind of message is not allowed at this stage of the game",
+			}
+			return
+		}
+		return message.SendToSpectators(c.Diet(), game, sender.Id)
+	}
+
+	if sender == nil {
+		err = fmt.Errorf("Not member of game")
+		return
+	}
 	return message.Send(c.Diet(), game, sender)
 }
 
+func AddSpectator(c common.WSContext) error {
+	return c.Transact(func(c common.WSContext) error {
+		decodedId, err := kol.DecodeId(c.Match()[1])
+		if err != nil {
+			return err
+		}
+		game := &Game{Id: decodedId}
+		if err := c.DB().Get(game); err != nil {
+			return fmt.Errorf("Game not found: %v", err)
+		}
+		if game.Private {
+			return fmt.Errorf("%+v is private", game)
+		}
+		if member, err := game.Member(c.DB(), c.Principal()); err != nil {
+			return err
+		} else if member != nil {
+			return fmt.Errorf("%+v is member of %v", member, game.Id)
+		}
+		if spectator, err := game.Spectator(c.DB(), c.Principal()); err != nil {
+			return err
+		} else if spectator != nil {
+			return fmt.Errorf("%+v is already spectator of %v", spectator, game.Id)
+		}
+		return c.DB().Set(&Spectator{
+			GameId: game.Id,
+			UserId: kol.Id(c.Principal()),
+		})
+	})
+}
+
+func DeleteSpectator(c common.WSContext) error {
+	return c.Transact(func(c common.WSContext) error {
+		decodedId, err := kol.DecodeId(c.Match()[1])
+		if err != nil {
+			return err
+		}
+		game := &Game{Id: decodedId}
+		spectator, err := game.Spectator(c.DB(), c.Principal())
+		if err != nil {
+			return err
+		}
+		if spectator == nil {
+			return fmt.Errorf("Not spectator of %v", game.Id)
+		}
+		return c.DB().Del(spectator)
+	})
+}
+
 func DeleteMember(c common.WSContext) error {
 	return c.Transact(func(c common.WSContext) error {
 		decodedId, err := kol.DecodeId(c.Match()[1])
@@ -356,7 +497,24 @@ func AddMember(c common.WSContext) error {
 			if err := c.DB().Set(&member); err != nil {
 				return err
 			}
-			if len(already) == len(variant.Nations)-1 {
+			if err := fireWebhooks(c.Diet(), &game, WebhookMemberJoined, webhookMemberJoined{
+				Members: len(already) + 1,
+			}); err != nil {
+				return err
+			}
+			if spectator, err := game.Spectator(c.DB(), c.Principal()); err != nil {
+				return err
+			} else if spectator != nil {
+				if err := c.DB().Del(spectator); err != nil {
+					return err
+				}
+			}
+			ep, err := epoch.Get(c.DB())
+			if err != nil {
+				return err
+			}
+			// Games that didn't have enough members at their scheduled start start as soon as they do
+			if len(already) == len(variant.Nations)-1 || (game.StartAt != 0 && game.StartAt <= ep && len(already)+1 >= game.MinimumMembers) {
 				if err := game.start(c.Diet()); err != nil {
 					return err
 				}
@@ -380,29 +538,95 @@ func Create(c common.WSContext) error {
 		SecretNation:          state.Game.SecretNation,
 		Deadlines:             state.Game.Deadlines,
 		ChatFlags:             state.Game.ChatFlags,
+		YearChatFlags:         state.Game.YearChatFlags,
+		PressCloseBefore:      state.Game.PressCloseBefore,
 		AllocationMethod:      state.Game.AllocationMethod,
 		NonCommitConsequences: state.Game.NonCommitConsequences,
 		NMRConsequences:       state.Game.NMRConsequences,
 		Ranking:               state.Game.Ranking,
+		MinimumMembers:        state.Game.MinimumMembers,
+		BotFill:               state.Game.BotFill,
+		Practice:              state.Game.Practice,
+		StartDelay:            state.Game.StartDelay,
+		ExpireDelay:           state.Game.ExpireDelay,
+		DeadlineLocation:      state.Game.DeadlineLocation,
+		DeadlineAlign:         state.Game.DeadlineAlign,
+		DeadlineAlignTo:       state.Game.DeadlineAlignTo,
+		DeadlineSkipDays:      state.Game.DeadlineSkipDays,
+		VacationPolicy:        state.Game.VacationPolicy,
 	}
 
-	if _, found := common.VariantMap[game.Variant]; !found {
+	variant, found := common.VariantMap[game.Variant]
+	if !found {
 		return fmt.Errorf("Unknown variant for %+v", game)
 	}
 
+	if game.MinimumMembers == 0 {
+		game.MinimumMembers = len(variant.Nations)
+	}
+	if game.MinimumMembers < 2 || game.MinimumMembers > len(variant.Nations) {
+		return fmt.Errorf("Illegal minimum members for %+v", game)
+	}
+
+	if game.StartDelay < 0 || game.ExpireDelay < 0 || (game.StartDelay > 0 && game.ExpireDelay > 0 && game.ExpireDelay <= game.StartDelay) {
+		return fmt.Errorf("Illegal start or expire delay for %+v", game)
+	}
+
+	if err := game.validateCalendar(); err != nil {
+		return err
+	}
+
+	if game.PressCloseBefore < 0 {
+		return fmt.Errorf("Illegal press window for %+v", game)
+	}
+
+	if game.VacationPolicy < common.VacationDisallowed || game.VacationPolicy > common.VacationHold {
+		return fmt.Errorf("Unknown vacation policy for %+v", game)
+	}
+
 	if _, found := common.AllocationMethodMap[game.AllocationMethod]; !found {
 		return fmt.Errorf("Unknown allocation method for %+v", game)
 	}
 
+	if _, found := botPlayers[game.BotFill]; game.BotFill != "" && !found {
+		return fmt.Errorf("Unknown bot for %+v", game)
+	}
+
+	if game.Practice {
+		// Nobody else can join practice games, and nothing in them counts
+		game.Private = true
+		game.Ranking = false
+		game.NonCommitConsequences = 0
+		game.NMRConsequences = 0
+		game.VacationPolicy = common.VacationDisallowed
+	}
+
+	game.OwnerId = kol.Id(c.Principal())
 	member := &Member{
 		UserId:           kol.Id(c.Principal()),
 		PreferredNations: state.Members[0].PreferredNations,
 	}
 	return c.Transact(func(c common.WSContext) error {
+		ep, err := epoch.Get(c.DB())
+		if err != nil {
+			return err
+		}
+		if game.StartDelay > 0 {
+			game.StartAt = ep + (time.Minute * time.Duration(game.StartDelay))
+		}
+		if game.ExpireDelay > 0 {
+			game.ExpireAt = ep + (time.Minute * time.Duration(game.ExpireDelay))
+		}
 		if err := c.DB().Set(game); err != nil {
 			return err
 		}
 		member.GameId = game.Id
-		return c.DB().Set(member)
+		if err := c.DB().Set(member); err != nil {
+			return err
+		}
+		if game.Practice {
+			return game.start(c.Diet())
+		}
+		return game.Schedule(c.Diet())
 	})
 }
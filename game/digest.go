@@ -0,0 +1,202 @@
+package game
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/zond/diplicity/common"
+	"github.com/zond/diplicity/user"
+	"github.com/zond/kcwraps/kol"
+)
+
+/*
+DigestEntry is a new phase, or a message to MemberId, waiting to be included in the next digest email of a user.
+Messages seen before the digest is sent are left out of it.
+*/
+type DigestEntry struct {
+	Id        kol.Id
+	UserId    kol.Id `kol:"index"`
+	GameId    kol.Id
+	PhaseId   kol.Id
+	MemberId  kol.Id
+	MessageId kol.Id
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+type DigestEntries []DigestEntry
+
+func (self DigestEntries) Len() int {
+	return len(self)
+}
+
+func (self DigestEntries) Less(i, j int) bool {
+	return self[i].CreatedAt.Before(self[j].CreatedAt)
+}
+
+func (self DigestEntries) Swap(i, j int) {
+	self[i], self[j] = self[j], self[i]
+}
+
+func StartDigests(c common.SkinnyContext) {
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+			if err := SendDigests(c); err != nil {
+				c.Errorf("Failed sending digests: %v", err)
+			}
+		}
+	}()
+}
+
+func SendDigests(c common.SkinnyContext) (err error) {
+	users := user.Users{}
+	if err = c.DB().Query().Where(kol.Equals{"Digest", true}).All(&users); err != nil {
+		return
+	}
+	now := time.Now()
+	for index, _ := range users {
+		u := &users[index]
+		if now.Sub(u.LastDigestAt) >= time.Minute*time.Duration(u.DigestMinutes) {
+			if err := c.Transact(func(c common.SkinnyContext) error {
+				return sendDigest(c, u, now)
+			}); err != nil {
+				c.Errorf("Failed sending digest to %#v: %v", u.Email, err)
+			}
+		}
+	}
+	return
+}
+
+func digestLines(c common.SkinnyContext, u *user.User, game *Game, entries DigestEntries) (result []string, err error) {
+	members, err := game.Members(c.DB())
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if entry.MessageId == nil {
+			var line string
+			if line, err = u.I("A new phase has been created"); err != nil {
+				return
+			}
+			result = append(result, line)
+			continue
+		}
+		if u.MessageEmailDisabled {
+			continue
+		}
+		message := &Message{Id: entry.MessageId}
+		if err = c.DB().Get(message); err == kol.NotFound {
+			err = nil
+			continue
+		} else if err != nil {
+			return
+		}
+		if message.SeenBy[entry.MemberId.String()] {
+			continue
+		}
+		senderName := string(common.Anonymous)
+		if !message.Grey {
+			for index, _ := range members {
+				if members[index].Id.Equals(message.SenderId) {
+					senderUser := &user.User{Id: members[index].UserId}
+					if err = c.DB().Get(senderUser); err != nil {
+						return
+					}
+					senderName = members[index].ShortName(game, senderUser)
+				}
+			}
+		}
+		result = append(result, fmt.Sprintf("%v: %v", senderName, message.Body))
+	}
+	return
+}
+
+func sendDigest(c common.SkinnyContext, u *user.User, now time.Time) (err error) {
+	entries := DigestEntries{}
+	if err = c.DB().Query().Where(kol.Equals{"UserId", u.Id}).All(&entries); err != nil {
+		return
+	}
+	// Entries are stored in the order they happened, but may come back in any order
+	sort.Sort(entries)
+	gameIds := []string{}
+	entriesByGame := map[string]DigestEntries{}
+	for _, entry := range entries {
+		if _, found := entriesByGame[entry.GameId.String()]; !found {
+			gameIds = append(gameIds, entry.GameId.String())
+		}
+		entriesByGame[entry.GameId.String()] = append(entriesByGame[entry.GameId.String()], entry)
+	}
+	sections := []common.MailSection{}
+	for _, gameId := range gameIds {
+		gameEntries := entriesByGame[gameId]
+		game := &Game{Id: gameEntries[0].GameId}
+		if err = c.DB().Get(game); err == kol.NotFound {
+			err = nil
+			continue
+		} else if err != nil {
+			return
+		}
+		var lines []string
+		if lines, err = digestLines(c, u, game, gameEntries); err != nil {
+			return
+		}
+		if len(lines) > 0 {
+			var description string
+			if description, err = game.Describe(c, u); err != nil {
+				return
+			}
+			sections = append(sections, common.MailSection{
+				Title:  description,
+				GameId: game.Id.String(),
+				Lines:  lines,
+			})
+		}
+	}
+	for index, _ := range entries {
+		if err = c.DB().Del(&entries[index]); err != nil {
+			return
+		}
+	}
+	u.LastDigestAt = now
+	if err = c.DB().Set(u); err != nil {
+		return
+	}
+	if len(sections) == 0 {
+		return
+	}
+	unsubTag := &common.UnsubscribeTag{
+		T: common.UnsubscribeMessageEmail,
+		U: u.Id,
+	}
+	unsubTag.H = unsubTag.Hash(c.Secret())
+	encodedUnsubTag, err := unsubTag.Encode()
+	if err != nil {
+		return
+	}
+	subject, err := u.I("Diplicity digest")
+	if err != nil {
+		return
+	}
+	mail := &common.Mail{
+		FromName:    "diplicity",
+		ReplyTo:     c.ReceiveAddress(),
+		To:          []string{u.Email},
+		Subject:     subject,
+		Unsubscribe: fmt.Sprintf("http://%v/unsubscribe/%v", u.DiplicityHost, encodedUnsubTag),
+	}
+	if err = c.RenderMail(mail, "digest", u.Language, common.MailData{
+		Translator:     u,
+		Host:           u.DiplicityHost,
+		UnsubscribeTag: encodedUnsubTag,
+		Sections:       sections,
+	}); err != nil {
+		return
+	}
+	if err := c.SendMessage(mail); err != nil {
+		c.Errorf("Failed queueing %#v to %v: %v", mail.Subject, mail.To, err)
+	}
+	return
+}
@@ -56,8 +56,17 @@ func (self SortedGames) Swap(i, j int) {
 	self.Games[j], self.Games[i] = self.Games[i], self.Games[j]
 }
 
+/*
+YearChatFlags replaces the chat flags of a game from the start of a given year.
+*/
+type YearChatFlags struct {
+	Year  int
+	Flags map[dip.PhaseType]common.ChatFlag
+}
+
 type Game struct {
-	Id kol.Id
+	Id      kol.Id
+	OwnerId kol.Id
 
 	Closed             bool             `kol:"index"`
 	Private            bool             `kol:"index"`
@@ -76,37 +85,143 @@ type Game struct {
 
 	Deadlines map[dip.PhaseType]Minutes
 
-	ChatFlags map[dip.PhaseType]common.ChatFlag
+	ChatFlags        map[dip.PhaseType]common.ChatFlag
+	YearChatFlags    []YearChatFlags
+	PressCloseBefore Minutes
 
 	NonCommitConsequences common.Consequence
 	NMRConsequences       common.Consequence
 
 	Ranking bool
+	// Practice games are played by the owner alone, in all seats or against bots, and don't affect ranking or reliability
+	Practice bool
+
+	VacationPolicy common.VacationPolicy
+
+	MinimumMembers int
+	StartDelay     Minutes
+	ExpireDelay    Minutes
+	StartAt        time.Duration
+	ExpireAt       time.Duration
+
+	// BotFill is the kind of bot taking the seats still empty when the game starts
+	BotFill string
+
+	Paused   bool
+	PausedAt time.Duration
+
+	DeadlineLocation string
+	DeadlineAlign    bool
+	DeadlineAlignTo  Minutes
+	DeadlineSkipDays []time.Weekday
 
 	CreatedAt time.Time
 	UpdatedAt time.Time
 }
 
+func (self *Game) chatFlags(phaseType dip.PhaseType, year int) (result common.ChatFlag) {
+	result = self.ChatFlags[phaseType]
+	latest := 0
+	for _, change := range self.YearChatFlags {
+		if change.Year <= year && change.Year > latest {
+			latest = change.Year
+			result = change.Flags[phaseType]
+		}
+	}
+	return
+}
+
 func (self *Game) Disallows(u *user.User) bool {
 	return (self.MinimumRanking != 0 && u.Ranking < self.MinimumRanking) ||
 		(self.MaximumRanking != 0 && u.Ranking > self.MaximumRanking) ||
 		u.Reliability() < self.MinimumReliability
 }
 
+func (self *Game) validateCalendar() (err error) {
+	if _, err = time.LoadLocation(self.DeadlineLocation); err != nil {
+		return
+	}
+	if self.DeadlineAlignTo < 0 || self.DeadlineAlignTo >= 24*60 {
+		err = fmt.Errorf("Can't align deadlines to %v minutes after midnight", self.DeadlineAlignTo)
+		return
+	}
+	skipped := map[time.Weekday]bool{}
+	for _, day := range self.DeadlineSkipDays {
+		if day < time.Sunday || day > time.Saturday {
+			err = fmt.Errorf("Unknown weekday %v", int(day))
+			return
+		}
+		skipped[day] = true
+	}
+	if len(skipped) == 7 {
+		err = fmt.Errorf("Can't skip every day of the week")
+		return
+	}
+	return
+}
+
+/*
+deadline returns the epoch of the deadline for a new phase of type typ created at epoch ep,
+moved forward to the configured time of day and past any skipped weekdays.
+*/
+func (self *Game) deadline(ep time.Duration, typ dip.PhaseType) (result time.Duration, err error) {
+	if self.Practice && self.Deadlines[typ] == 0 {
+		// Practice phases without deadlines wait until everyone has committed
+		return
+	}
+	result = ep + (time.Minute * time.Duration(self.Deadlines[typ]))
+	if !self.DeadlineAlign && len(self.DeadlineSkipDays) == 0 {
+		return
+	}
+	loc, err := time.LoadLocation(self.DeadlineLocation)
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	wall := alignTime(now.Add(result-ep).In(loc), self.DeadlineAlign, self.DeadlineAlignTo, self.DeadlineSkipDays)
+	result = ep + wall.Sub(now)
+	return
+}
+
+func alignTime(t time.Time, align bool, alignTo Minutes, skipDays []time.Weekday) (result time.Time) {
+	result = t
+	if align {
+		result = time.Date(t.Year(), t.Month(), t.Day(), int(alignTo)/60, int(alignTo)%60, 0, 0, t.Location())
+		if result.Before(t) {
+			result = time.Date(t.Year(), t.Month(), t.Day()+1, int(alignTo)/60, int(alignTo)%60, 0, 0, t.Location())
+		}
+	}
+	skipped := map[time.Weekday]bool{}
+	for _, day := range skipDays {
+		skipped[day] = true
+	}
+	if len(skipped) == 7 {
+		return
+	}
+	for skipped[result.Weekday()] {
+		result = time.Date(result.Year(), result.Month(), result.Day()+1, result.Hour(), result.Minute(), result.Second(), result.Nanosecond(), result.Location())
+	}
+	return
+}
+
 func (self *Game) allocate(d *kol.DB, phase *Phase) (err error) {
 	members, err := self.Members(d)
 	if err != nil {
 		return
 	}
+	nations := common.VariantMap[self.Variant].Nations
 	switch self.AllocationMethod {
 	case common.RandomString:
-		for memberIndex, nationIndex := range rand.Perm(len(members)) {
-			members[memberIndex].Nation = common.VariantMap[self.Variant].Nations[nationIndex]
+		for memberIndex, nationIndex := range rand.Perm(len(nations))[:len(members)] {
+			members[memberIndex].Nation = nations[nationIndex]
 		}
 	case common.PreferencesString:
 		prefs := make([][]dip.Nation, len(members))
 		for index, member := range members {
 			prefs[index] = member.PreferredNations
+			if len(prefs[index]) != len(nations) {
+				prefs[index] = nations
+			}
 		}
 		for index, nation := range optimizePreferences(prefs) {
 			members[index].Nation = nation
@@ -134,9 +249,73 @@ func (self *Game) allocate(d *kol.DB, phase *Phase) (err error) {
 	return
 }
 
+func (self *Game) vacationing(d *kol.DB, member *Member, at time.Time) (result *user.Vacation, err error) {
+	if self.VacationPolicy == common.VacationDisallowed || member.Bot != "" {
+		return
+	}
+	u := &user.User{Id: member.UserId}
+	if err = d.Get(u); err != nil {
+		return
+	}
+	result = u.OnVacation(at)
+	return
+}
+
+func (self *Game) extendForVacations(c common.SkinnyContext, phase *Phase) (extended bool, err error) {
+	members, err := self.Members(c.DB())
+	if err != nil {
+		return
+	}
+	ep, err := epoch.Get(c.DB())
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	deadline := phase.Deadline
+	for index, _ := range members {
+		if !members[index].Committed && !members[index].NoWait {
+			var vacation *user.Vacation
+			if vacation, err = self.vacationing(c.DB(), &members[index], now); err != nil {
+				return
+			}
+			if vacation != nil {
+				if until := ep + vacation.End.Sub(now); until > deadline {
+					deadline = until
+				}
+			}
+		}
+	}
+	if deadline > phase.Deadline {
+		c.Infof("Extending %v/%v by %v due to vacations", self.Id, phase.Id, deadline-phase.Deadline)
+		phase.Deadline = deadline
+		if err = c.DB().Set(phase); err != nil {
+			return
+		}
+		extended = true
+	}
+	return
+}
+
 func (self *Game) endPhaseConsequences(c common.SkinnyContext, phase *Phase, member *Member, opts dip.Options, waitFor, active, nonSurrendering *[]*Member) (err error) {
 	surrender := false
-	if !member.Committed {
+	onVacation := false
+	// Members already on autopilot got their consequences when they missed the deadline, and the bot commits for them
+	onAutopilot := member.Autopilot
+	committed := member.Committed
+	nmr := len(phase.Orders[member.Nation]) == 0
+	if onAutopilot {
+		c.Infof("Not applying consequences to %#v, on autopilot", string(member.UserId))
+	} else if !committed && self.VacationPolicy == common.VacationHold {
+		var vacation *user.Vacation
+		if vacation, err = self.vacationing(c.DB(), member, time.Now()); err != nil {
+			return
+		}
+		if vacation != nil {
+			c.Infof("Not applying consequences to %#v, on vacation until %v", string(member.UserId), vacation.End)
+			onVacation = true
+		}
+	}
+	if !committed && !onVacation && !onAutopilot {
 		alreadyHitReliability := false
 		if (self.NonCommitConsequences & common.ReliabilityHit) == common.ReliabilityHit {
 			if err = member.ReliabilityDelta(c.DB(), -1); err != nil {
@@ -153,7 +332,11 @@ func (self *Game) endPhaseConsequences(c common.SkinnyContext, phase *Phase, mem
 			c.Infof("Setting %#v to Surrender because of %+v, %+v and %+v", string(member.UserId), self, member, phase)
 			surrender = true
 		}
-		if len(phase.Orders[member.Nation]) == 0 {
+		if (self.NonCommitConsequences & common.Autopilot) == common.Autopilot {
+			c.Infof("Setting %#v to Autopilot because of %+v, %+v and %+v", string(member.UserId), self, member, phase)
+			member.Autopilot = true
+		}
+		if nmr {
 			if !alreadyHitReliability && (self.NMRConsequences&common.ReliabilityHit) == common.ReliabilityHit {
 				if err = member.ReliabilityDelta(c.DB(), -1); err != nil {
 					return
@@ -168,8 +351,12 @@ func (self *Game) endPhaseConsequences(c common.SkinnyContext, phase *Phase, mem
 				c.Infof("Setting %#v to Surrender because of %+v, %+v and %+v", string(member.UserId), self, member, phase)
 				surrender = true
 			}
+			if (self.NMRConsequences & common.Autopilot) == common.Autopilot {
+				c.Infof("Setting %#v to Autopilot because of %+v, %+v and %+v", string(member.UserId), self, member, phase)
+				member.Autopilot = true
+			}
 		}
-	} else {
+	} else if committed && !onAutopilot {
 		if (self.NonCommitConsequences&common.ReliabilityHit) == common.ReliabilityHit || (self.NMRConsequences&common.ReliabilityHit) == common.ReliabilityHit {
 			if err = member.ReliabilityDelta(c.DB(), 1); err != nil {
 				return
@@ -180,12 +367,16 @@ func (self *Game) endPhaseConsequences(c common.SkinnyContext, phase *Phase, mem
 	if !surrender {
 		*nonSurrendering = append(*nonSurrendering, member)
 	}
+	member.ExtensionVote = 0
 	member.Options = opts
 	if member.NoWait {
 		member.Committed = false
 		member.NoOrders = false
 	} else {
-		*active = append(*active, member)
+		// Bots and members on autopilot don't keep the game alive
+		if !member.Autopilot && member.Bot == "" {
+			*active = append(*active, member)
+		}
 		if len(opts) == 0 {
 			member.Committed = true
 			member.NoOrders = true
@@ -211,11 +402,16 @@ func (self *Game) end(c common.SkinnyContext, phase *Phase, members Members, win
 	if err = c.DB().Set(phase); err != nil {
 		return
 	}
-	if self.Ranking && winner != nil {
+	if err = fireWebhooks(c, self, WebhookGameEnded, webhookGameEnded{
+		EndReason: reason,
+	}); err != nil {
+		return
+	}
+	if self.Ranking && !self.Practice && winner != nil && winner.Bot == "" {
 		pot := 0.0
 		spend := 0.0
 		for index, _ := range members {
-			if !members[index].Id.Equals(winner.Id) {
+			if members[index].Bot == "" && !members[index].Id.Equals(winner.Id) {
 				user := &user.User{Id: members[index].UserId}
 				if err = c.DB().Get(user); err != nil {
 					return
@@ -278,7 +474,9 @@ func (self *Game) resolve(c common.SkinnyContext, phase *Phase) (err error) {
 			Season:      nextDipPhase.Season(),
 			Year:        nextDipPhase.Year(),
 			Type:        nextDipPhase.Type(),
-			Deadline:    epoch + (time.Minute * time.Duration(self.Deadlines[nextDipPhase.Type()])),
+		}
+		if nextPhase.Deadline, err = self.deadline(epoch, nextPhase.Type); err != nil {
+			return
 		}
 		// Set the new phase positions
 		var resolutions map[dip.Province]error
@@ -308,11 +506,26 @@ func (self *Game) resolve(c common.SkinnyContext, phase *Phase) (err error) {
 			}
 		}
 
+		// Let the bots give their orders, and stop waiting for them
+		if err = self.playBots(c, nextPhase, members); err != nil {
+			return
+		}
+		humans := []*Member{}
+		for _, member := range waitFor {
+			if !member.Committed {
+				humans = append(humans, member)
+			}
+		}
+		waitFor = humans
+
 		// Mark the old phase as resolved, and save it
 		phase.Resolved = true
 		if err = c.DB().Set(phase); err != nil {
 			return
 		}
+		if err = fireWebhooks(c, self, WebhookPhaseResolved, newWebhookPhase(phase)); err != nil {
+			return
+		}
 
 		// If we have a solo victor, end and return
 		if winner := nextDipPhase.Winner(state); winner != nil {
@@ -324,8 +537,7 @@ func (self *Game) resolve(c common.SkinnyContext, phase *Phase) (err error) {
 				}
 			}
 			if winnerMember == nil {
-				err = fmt.Errorf("None of %+v has nation %#v??", members, *winner)
-				return
+				c.Infof("None of %+v has nation %#v, it must be in civil disorder", members, *winner)
 			}
 			if err = self.end(c, nextPhase, members, winnerMember, common.SoloVictory(*winner)); err != nil {
 				return
@@ -333,7 +545,7 @@ func (self *Game) resolve(c common.SkinnyContext, phase *Phase) (err error) {
 			return
 		}
 
-		// End the game now if nobody is active anymore
+		// End the game now if no human is active anymore
 		if len(active) == 0 {
 			if err = self.end(c, nextPhase, members, nil, common.ZeroActiveMembers); err != nil {
 				return
@@ -363,6 +575,182 @@ func (self *Game) resolve(c common.SkinnyContext, phase *Phase) (err error) {
 			return
 		}
 		phase = nextPhase
+		// The next phase may contain orders from bots
+		if state, err = phase.State(); err != nil {
+			return
+		}
+	}
+	// Continue in a new transaction, to not leave the stored phase unresolved and unscheduled
+	c.Infof("Resolved 100 phases of %v without waiting for anyone, continuing with %v later", self.Id, phase.ShortString())
+	c.BetweenTransactions(func(c common.SkinnyContext) {
+		if err := phase.autoResolve(c); err != nil {
+			c.Errorf("Failed continuing resolution of %+v: %v", phase, err)
+		}
+	})
+	return
+}
+
+func (self *Game) countVotes(c common.SkinnyContext, members Members) (err error) {
+	active := 0
+	pause := 0
+	resume := 0
+	extend := 0
+	var extension Minutes
+	for index, _ := range members {
+		member := &members[index]
+		// Like when resolving phases, absent members don't count
+		if member.NoWait || member.Bot != "" || member.Autopilot {
+			continue
+		}
+		if self.VacationPolicy == common.VacationHold {
+			var vacation *user.Vacation
+			if vacation, err = self.vacationing(c.DB(), member, time.Now()); err != nil {
+				return
+			}
+			if vacation != nil {
+				continue
+			}
+		}
+		active++
+		if member.PauseVote {
+			pause++
+		}
+		if member.ResumeVote {
+			resume++
+		}
+		if member.ExtensionVote > 0 {
+			extend++
+			if extension == 0 || member.ExtensionVote < extension {
+				extension = member.ExtensionVote
+			}
+		}
+	}
+	if active == 0 {
+		return
+	}
+	if !self.Paused && pause == active {
+		if err = self.pause(c, members); err != nil {
+			return
+		}
+	} else if self.Paused && resume == active {
+		if err = self.resume(c, members); err != nil {
+			return
+		}
+	}
+	if extend == active {
+		if err = self.extend(c, members, extension); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (self *Game) pause(c common.SkinnyContext, members Members) (err error) {
+	ep, err := epoch.Get(c.DB())
+	if err != nil {
+		return
+	}
+	self.Paused = true
+	self.PausedAt = ep
+	if err = c.DB().Set(self); err != nil {
+		return
+	}
+	for index, _ := range members {
+		members[index].PauseVote = false
+		if err = c.DB().Set(&members[index]); err != nil {
+			return
+		}
+	}
+	c.Infof("Paused %v", self.Id)
+	return
+}
+
+func (self *Game) resume(c common.SkinnyContext, members Members) (err error) {
+	ep, err := epoch.Get(c.DB())
+	if err != nil {
+		return
+	}
+	_, phase, err := self.Phase(c.DB(), 0)
+	if err != nil {
+		return
+	}
+	if phase == nil {
+		err = fmt.Errorf("No phase for %+v found", self)
+		return
+	}
+	phase.Deadline += ep - self.PausedAt
+	if err = c.DB().Set(phase); err != nil {
+		return
+	}
+	self.Paused = false
+	self.PausedAt = 0
+	if err = c.DB().Set(self); err != nil {
+		return
+	}
+	for index, _ := range members {
+		members[index].ResumeVote = false
+		if err = c.DB().Set(&members[index]); err != nil {
+			return
+		}
+	}
+	c.Infof("Resumed %v", self.Id)
+	// Everyone may have committed while the game was paused
+	done, err := self.allDone(c.DB(), members)
+	if err != nil {
+		return
+	}
+	if done {
+		return self.resolve(c, phase)
+	}
+	return phase.Schedule(c)
+}
+
+/*
+allDone returns whether no member has to commit before the phase can resolve, because they have committed,
+don't want to be waited for or are on vacation in a game holding for vacations.
+*/
+func (self *Game) allDone(d *kol.DB, members Members) (result bool, err error) {
+	count := 0
+	now := time.Now()
+	for index, _ := range members {
+		if members[index].Committed || members[index].NoWait {
+			count++
+		} else if self.VacationPolicy == common.VacationHold {
+			var vacation *user.Vacation
+			if vacation, err = self.vacationing(d, &members[index], now); err != nil {
+				return
+			}
+			if vacation != nil {
+				count++
+			}
+		}
+	}
+	result = count == len(members)
+	return
+}
+
+func (self *Game) extend(c common.SkinnyContext, members Members, extension Minutes) (err error) {
+	_, phase, err := self.Phase(c.DB(), 0)
+	if err != nil {
+		return
+	}
+	if phase == nil {
+		err = fmt.Errorf("No phase for %+v found", self)
+		return
+	}
+	phase.Deadline += time.Minute * time.Duration(extension)
+	if err = c.DB().Set(phase); err != nil {
+		return
+	}
+	for index, _ := range members {
+		members[index].ExtensionVote = 0
+		if err = c.DB().Set(&members[index]); err != nil {
+			return
+		}
+	}
+	c.Infof("Extended %v/%v by %v minutes", self.Id, phase.Id, extension)
+	if !self.Paused {
+		return phase.Schedule(c)
 	}
 	return
 }
@@ -392,6 +780,182 @@ func (self *Game) Describe(c common.SkinnyContext, trans common.Translator) (res
 	return
 }
 
+func ScheduleUnstartedGames(c common.SkinnyContext) (err error) {
+	unstarted := Games{}
+	if err = c.DB().Query().Where(kol.Equals{"State", common.GameStateCreated}).All(&unstarted); err != nil {
+		return
+	}
+	for index, _ := range unstarted {
+		if err := unstarted[index].Schedule(c); err != nil {
+			c.Errorf("Failed scheduling %v: %v", unstarted[index].Id, err)
+		}
+	}
+	return
+}
+
+func (self *Game) Schedule(c common.SkinnyContext) error {
+	if self.State == common.GameStateCreated && (self.StartAt != 0 || self.ExpireAt != 0) {
+		ep, err := epoch.Get(c.DB())
+		if err != nil {
+			return err
+		}
+		c.BetweenTransactions(func(c common.SkinnyContext) {
+			if self.StartAt != 0 {
+				scheduleAt(c, self.StartAt-ep, fmt.Sprintf("start of %v", self.Id), self.autoStart)
+			}
+			if self.ExpireAt != 0 {
+				scheduleAt(c, self.ExpireAt-ep, fmt.Sprintf("expiry of %v", self.Id), self.autoExpire)
+			}
+		})
+	}
+	return nil
+}
+
+func scheduleAt(c common.SkinnyContext, timeout time.Duration, desc string, f func(c common.SkinnyContext) error) {
+	if timeout > 0 {
+		time.AfterFunc(timeout, func() {
+			if err := f(c); err != nil {
+				c.Errorf("Failed %v after %v: %v", desc, timeout, err)
+			}
+		})
+		c.Debugf("Scheduled %v in %v at %v", desc, timeout, time.Now().Add(timeout))
+	} else {
+		c.Debugf("Running %v immediately, it is %v overdue", desc, -timeout)
+		if err := f(c); err != nil {
+			c.Errorf("Failed %v immediately: %v", desc, err)
+		}
+	}
+}
+
+func (self *Game) autoStart(c common.SkinnyContext) (err error) {
+	c.Infof("Auto starting %v due to scheduled start", self.Id)
+	return c.Transact(func(c common.SkinnyContext) (err error) {
+		if err = c.DB().Get(self); err != nil {
+			if err == kol.NotFound {
+				c.Infof("%v no longer exists", self.Id)
+				err = nil
+			}
+			return
+		}
+		if self.State != common.GameStateCreated {
+			c.Infof("%+v was already started", self)
+			return
+		}
+		members, err := self.Members(c.DB())
+		if err != nil {
+			return
+		}
+		if self.BotFill == "" && len(members) < self.MinimumMembers {
+			var ep time.Duration
+			if ep, err = epoch.Get(c.DB()); err != nil {
+				return
+			}
+			// Games without an expiry never expire, and AddMember starts them once they have enough members
+			if self.ExpireAt == 0 || self.ExpireAt > ep {
+				c.Infof("%+v only has %v members, needs %v to start, leaving it open until it has enough members", self, len(members), self.MinimumMembers)
+				return
+			}
+			c.Infof("%+v only has %v members, needs %v to start, expiring it", self, len(members), self.MinimumMembers)
+			return self.expire(c, members)
+		}
+		return self.start(c)
+	})
+}
+
+func (self *Game) autoExpire(c common.SkinnyContext) (err error) {
+	c.Infof("Auto expiring %v due to timeout", self.Id)
+	return c.Transact(func(c common.SkinnyContext) (err error) {
+		if err = c.DB().Get(self); err != nil {
+			if err == kol.NotFound {
+				c.Infof("%v no longer exists", self.Id)
+				err = nil
+			}
+			return
+		}
+		if self.State != common.GameStateCreated {
+			c.Infof("%+v was already started", self)
+			return
+		}
+		members, err := self.Members(c.DB())
+		if err != nil {
+			return
+		}
+		return self.expire(c, members)
+	})
+}
+
+/*
+expire deletes the game and its members, and tells the members that it never started.
+*/
+func (self *Game) expire(c common.SkinnyContext, members Members) (err error) {
+	for index, _ := range members {
+		if err = c.DB().Del(&members[index]); err != nil {
+			return
+		}
+	}
+	if err = c.DB().Del(self); err != nil {
+		return
+	}
+	sent := map[string]bool{}
+	for _, member := range members {
+		if member.Bot != "" || sent[member.UserId.String()] {
+			continue
+		}
+		sent[member.UserId.String()] = true
+		u := &user.User{Id: member.UserId}
+		if err := c.DB().Get(u); err != nil {
+			c.Errorf("Failed loading %v: %v", member.UserId, err)
+			continue
+		}
+		if u.PhaseEmailDisabled || u.Bot {
+			continue
+		}
+		if err := self.expiryEmailTo(c, len(members), u); err != nil {
+			c.Errorf("Failed sending to %#v: %v", u.Id.String(), err)
+		}
+	}
+	return
+}
+
+func (self *Game) expiryEmailTo(c common.SkinnyContext, joined int, user *user.User) (err error) {
+	unsubTag := &common.UnsubscribeTag{
+		T: common.UnsubscribePhaseEmail,
+		U: user.Id,
+	}
+	unsubTag.H = unsubTag.Hash(c.Secret())
+	encodedUnsubTag, err := unsubTag.Encode()
+	if err != nil {
+		return
+	}
+	subject, err := user.I("The game was cancelled")
+	if err != nil {
+		return
+	}
+	body, err := user.I("Only %v of the %v players needed joined the game before it had to start.", joined, self.MinimumMembers)
+	if err != nil {
+		return
+	}
+	mail := &common.Mail{
+		FromName:    "diplicity",
+		ReplyTo:     c.ReceiveAddress(),
+		To:          []string{user.Email},
+		Subject:     subject,
+		Unsubscribe: fmt.Sprintf("http://%v/unsubscribe/%v", user.DiplicityHost, encodedUnsubTag),
+	}
+	if err = c.RenderMail(mail, "expiry", user.Language, common.MailData{
+		Translator:     user,
+		Host:           user.DiplicityHost,
+		Body:           body,
+		UnsubscribeTag: encodedUnsubTag,
+	}); err != nil {
+		return
+	}
+	if err := c.SendMessage(mail); err != nil {
+		c.Errorf("Failed queueing %#v to %v: %v", mail.Subject, mail.To, err)
+	}
+	return
+}
+
 func (self *Game) start(c common.SkinnyContext) (err error) {
 	if self.State != common.GameStateCreated {
 		err = fmt.Errorf("%+v is already started", self)
@@ -424,20 +988,50 @@ func (self *Game) start(c common.SkinnyContext) (err error) {
 		Season:      startPhase.Season(),
 		Year:        startPhase.Year(),
 		Type:        startPhase.Type(),
-		Deadline:    epoch + (time.Minute * time.Duration(self.Deadlines[startPhase.Type()])),
+	}
+	if phase.Deadline, err = self.deadline(epoch, phase.Type); err != nil {
+		return
 	}
 	phase.Units, phase.SupplyCenters, phase.Dislodgeds, phase.Dislodgers, phase.Bounces, _ = startState.Dump()
 	if err = c.DB().Set(phase); err != nil {
 		return
 	}
+	if self.BotFill != "" {
+		if err = self.fillWithBots(c, self.BotFill); err != nil {
+			return
+		}
+	} else if self.Practice {
+		if err = self.fillWithOwner(c); err != nil {
+			return
+		}
+	}
 	if err = self.allocate(c.DB(), phase); err != nil {
 		return
 	}
-	if err = phase.Schedule(c); err != nil {
+	members, err := self.Members(c.DB())
+	if err != nil {
 		return
 	}
-	phase.SendStartedEmails(c, self)
-	return
+	if err = self.playBots(c, phase, members); err != nil {
+		return
+	}
+	if err = c.DB().Set(phase); err != nil {
+		return
+	}
+	if err = fireWebhooks(c, self, WebhookGameStarted, newWebhookPhase(phase)); err != nil {
+		return
+	}
+	for _, member := range members {
+		if !member.Committed {
+			if err = phase.Schedule(c); err != nil {
+				return
+			}
+			phase.SendStartedEmails(c, self)
+			return
+		}
+	}
+	// Nobody but bots had anything to do
+	return self.resolve(c, phase)
 }
 
 func (self *Game) Updated(d *kol.DB, old *Game) {
@@ -480,14 +1074,28 @@ func (self *Game) Members(d *kol.DB) (result Members, err error) {
 	return
 }
 
-func (self *Game) UnseenMessages(d *kol.DB, viewer kol.Id) (result map[string]int, err error) {
+func (self *Game) Spectators(d *kol.DB) (result Spectators, err error) {
+	err = d.Query().Where(kol.Equals{"GameId", self.Id}).All(&result)
+	return
+}
+
+func (self *Game) Spectator(d *kol.DB, email string) (result *Spectator, err error) {
+	var spectator Spectator
+	var found bool
+	if found, err = d.Query().Where(kol.And{kol.Equals{"GameId", self.Id}, kol.Equals{"UserId", kol.Id(email)}}).First(&spectator); found && err == nil {
+		result = &spectator
+	}
+	return
+}
+
+func (self *Game) UnseenMessages(d *kol.DB, viewer *Member) (result map[string]int, err error) {
 	msgs, err := self.Messages(d)
 	if err != nil {
 		return
 	}
 	result = map[string]int{}
 	for _, msg := range msgs {
-		if msg.RecipientIds[viewer.String()] && !msg.SeenBy[viewer.String()] {
+		if msg.RecipientIds[viewer.Id.String()] && !msg.SeenBy[viewer.Id.String()] {
 			result[msg.ChannelId()]++
 		}
 	}
@@ -503,7 +1111,7 @@ func (self *Game) ToState(d *kol.DB, members Members, member *Member) (result Ga
 	if phase != nil {
 		ordinal = phase.Ordinal
 	}
-	return self.toStateWithPhase(d, members, member, phase.redact(member), ordinal)
+	return self.toStateWithPhase(d, members, member, phase.redact(members.viewerNations(member)...), ordinal)
 }
 
 func (self *Game) ToStateWithPhaseOrdinal(d *kol.DB, members Members, member *Member, ordinal int) (result GameState, err error) {
@@ -516,7 +1124,7 @@ func (self *Game) ToStateWithPhaseOrdinal(d *kol.DB, members Members, member *Me
 		return
 	}
 	if last == phase {
-		phase = phase.redact(member)
+		phase = phase.redact(members.viewerNations(member)...)
 	}
 	return self.toStateWithPhase(d, members, member, phase, last.Ordinal)
 }
@@ -532,26 +1140,54 @@ func (self *Game) toStateWithPhase(d *kol.DB, members Members, member *Member, p
 	}
 	unseen := map[string]int{}
 	if member != nil {
-		unseen, err = self.UnseenMessages(d, member.Id)
+		unseen, err = self.UnseenMessages(d, member)
 		if err != nil {
 			return
 		}
 	}
+	spectators, err := self.Spectators(d)
+	if err != nil {
+		return
+	}
 	var timeLeft time.Duration
-	if phase != nil {
-		timeLeft, err = epoch.Get(d)
-		if err != nil {
-			return
+	if phase != nil && phase.Deadline != 0 {
+		if self.Paused {
+			timeLeft = self.PausedAt
+		} else {
+			timeLeft, err = epoch.Get(d)
+			if err != nil {
+				return
+			}
 		}
 		timeLeft = phase.Deadline - timeLeft
 	}
+	var chatFlags common.ChatFlag
+	switch self.State {
+	case common.GameStateCreated:
+		chatFlags = self.ChatFlags[common.BeforeGamePhaseType]
+	case common.GameStateEnded:
+		chatFlags = self.ChatFlags[common.AfterGamePhaseType]
+	default:
+		if phase != nil {
+			chatFlags = self.chatFlags(phase.Type, phase.Year)
+		}
+	}
+	// Only the owner gets to see who the owner is
+	game := self
+	if !self.OwnerId.Equals(kol.Id(email)) {
+		cpy := *self
+		cpy.OwnerId = nil
+		game = &cpy
+	}
 	result = GameState{
-		Game:           self,
-		UnseenMessages: unseen,
-		Members:        memberStates,
-		TimeLeft:       timeLeft,
-		Phase:          phase,
-		Phases:         phases,
+		Game:             game,
+		UnseenMessages:   unseen,
+		Members:          memberStates,
+		TimeLeft:         timeLeft,
+		Phase:            phase,
+		Phases:           phases,
+		Spectators:       len(spectators),
+		CurrentChatFlags: chatFlags,
 	}
 	return
 }
@@ -567,7 +1203,7 @@ func (self *Game) Messages(d *kol.DB) (result Messages, err error) {
 func (self *Game) Member(d *kol.DB, email string) (result *Member, err error) {
 	var member Member
 	var found bool
-	if found, err = d.Query().Where(kol.And{kol.Equals{"GameId", self.Id}, kol.Equals{"UserId", kol.Id(email)}}).First(&member); found && err == nil {
+	if found, err = d.Query().Where(kol.And{kol.Equals{"GameId", self.Id}, kol.Equals{"UserId", kol.Id(email)}}).First(&member); found && err == nil && member.Bot == "" {
 		result = &member
 	}
 	return
@@ -578,13 +1214,15 @@ func (self *Game) Users(d *kol.DB) (result user.Users, err error) {
 	if err != nil {
 		return
 	}
-	result = make(user.Users, len(members))
-	for index, member := range members {
+	for _, member := range members {
+		if member.Bot != "" {
+			continue
+		}
 		user := user.User{Id: member.UserId}
 		if err = d.Get(&user); err != nil {
 			return
 		}
-		result[index] = user
+		result = append(result, user)
 	}
 	return
 }
@@ -1,9 +1,15 @@
 package game
 
 import (
+	"bytes"
 	dip "github.com/zond/godip/common"
+	"net"
 	"reflect"
+	"sort"
+	"strings"
 	"testing"
+	"time"
+	"unicode/utf8"
 )
 
 func TestOptimizePreferences(t *testing.T) {
@@ -13,3 +19,188 @@ func TestOptimizePreferences(t *testing.T) {
 		t.Errorf("Wanted %v, but got %v", wanted, found)
 	}
 }
+
+func TestOptimizePreferencesFewerMembers(t *testing.T) {
+	wanted := []dip.Nation{"B", "A"}
+	found := optimizePreferences([][]dip.Nation{[]dip.Nation{"B", "A", "C", "D"}, []dip.Nation{"B", "A", "C", "D"}})
+	if !reflect.DeepEqual(found, wanted) {
+		t.Errorf("Wanted %v, but got %v", wanted, found)
+	}
+}
+
+func TestAlignTime(t *testing.T) {
+	// 2014-03-14 was a Friday
+	start := time.Date(2014, 3, 14, 3, 0, 0, 0, time.UTC)
+	if found, wanted := alignTime(start, false, 0, nil), start; !found.Equal(wanted) {
+		t.Errorf("Wanted %v, but got %v", wanted, found)
+	}
+	if found, wanted := alignTime(start, true, 18*60, nil), time.Date(2014, 3, 14, 18, 0, 0, 0, time.UTC); !found.Equal(wanted) {
+		t.Errorf("Wanted %v, but got %v", wanted, found)
+	}
+	if found, wanted := alignTime(start, true, 2*60, nil), time.Date(2014, 3, 15, 2, 0, 0, 0, time.UTC); !found.Equal(wanted) {
+		t.Errorf("Wanted %v, but got %v", wanted, found)
+	}
+	weekend := []time.Weekday{time.Saturday, time.Sunday}
+	if found, wanted := alignTime(start, true, 2*60, weekend), time.Date(2014, 3, 17, 2, 0, 0, 0, time.UTC); !found.Equal(wanted) {
+		t.Errorf("Wanted %v, but got %v", wanted, found)
+	}
+	if found, wanted := alignTime(start.AddDate(0, 0, 1), false, 0, weekend), time.Date(2014, 3, 17, 3, 0, 0, 0, time.UTC); !found.Equal(wanted) {
+		t.Errorf("Wanted %v, but got %v", wanted, found)
+	}
+}
+
+func TestWriteICalLine(t *testing.T) {
+	buf := &bytes.Buffer{}
+	writeICalLine(buf, "SUMMARY:"+strings.Repeat("åa", 40))
+	for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\r\n"), "\r\n") {
+		if len(line) > 75 {
+			t.Errorf("Wanted lines of at most 75 octets, but got %#v", line)
+		}
+		if !utf8.ValidString(line) {
+			t.Errorf("Wanted valid UTF-8, but got %#v", line)
+		}
+	}
+	if unfolded := strings.Replace(buf.String(), "\r\n ", "", -1); unfolded != "SUMMARY:"+strings.Repeat("åa", 40)+"\r\n" {
+		t.Errorf("Wanted the folded line to unfold to the original, but got %#v", unfolded)
+	}
+}
+
+func TestBotCandidates(t *testing.T) {
+	opts := dip.Options{
+		dip.Province("par"): dip.Options{
+			dip.OrderType("Hold"): dip.Options{
+				dip.SrcProvince("par"): nil,
+			},
+			dip.OrderType("Move"): dip.Options{
+				dip.SrcProvince("par"): dip.Options{
+					dip.Province("bur"): nil,
+					dip.Province("pic"): nil,
+				},
+			},
+			dip.OrderType("Build"): dip.Options{
+				dip.UnitType("Army"): dip.Options{
+					dip.SrcProvince("par"): nil,
+				},
+			},
+		},
+	}
+	found := []string{}
+	for _, order := range botCandidates(opts)[dip.Province("par")] {
+		found = append(found, strings.Join(order, " "))
+	}
+	sort.Strings(found)
+	wanted := []string{"par Build Army", "par Hold", "par Move bur", "par Move pic"}
+	if !reflect.DeepEqual(found, wanted) {
+		t.Errorf("Wanted %v, but got %v", wanted, found)
+	}
+}
+
+func TestRollbackRestoresLatestPhase(t *testing.T) {
+	phases := Phases{}
+	for _, ordinal := range []int{3, 0, 4, 1, 2} {
+		phases = append(phases, Phase{Ordinal: ordinal, Resolved: ordinal != 4})
+	}
+	target, later := splitPhases(phases, 1)
+	if target == nil || target.Ordinal != 1 {
+		t.Fatalf("Wanted phase 1 as target, but got %+v", target)
+	}
+	found := []int{}
+	for _, phase := range later {
+		found = append(found, phase.Ordinal)
+	}
+	if wanted := []int{2, 3, 4}; !reflect.DeepEqual(found, wanted) {
+		t.Errorf("Wanted %v archived, but got %v", wanted, found)
+	}
+	rollback := &Rollback{
+		Target: *target,
+		Phases: later,
+	}
+	if latest := rollback.latest(); latest.Ordinal != 4 || latest.Resolved {
+		t.Errorf("Wanted unresolved phase 4 to be restored as current, but got %+v", latest)
+	}
+	rollback.Phases = Phases{later[2], later[1], later[0]}
+	if latest := rollback.latest(); latest.Ordinal != 4 {
+		t.Errorf("Wanted phase 4 to be restored as current from a newest first archive, but got %+v", latest)
+	}
+	if _, later = splitPhases(phases, 5); len(later) != 0 {
+		t.Errorf("Wanted nothing archived after the last phase, but got %v", later)
+	}
+}
+
+func TestValidateWebhookURL(t *testing.T) {
+	lookup := func(host string) ([]net.IP, error) {
+		if host == "rebound.tld" {
+			return []net.IP{net.ParseIP("8.8.8.8"), net.ParseIP("10.0.0.1")}, nil
+		}
+		return []net.IP{net.ParseIP("8.8.8.8")}, nil
+	}
+	for _, raw := range []string{"http://example.tld/hook", "https://8.8.8.8:8443/hook"} {
+		if err := validateWebhookURL(raw, lookup); err != nil {
+			t.Errorf("Wanted %#v to be allowed, but got %v", raw, err)
+		}
+	}
+	for _, raw := range []string{
+		"ftp://example.tld/hook",
+		"http:///hook",
+		"http://127.0.0.1:8080/",
+		"http://[::1]/",
+		"http://169.254.169.254/latest/meta-data",
+		"http://192.168.1.1/",
+		"http://100.64.0.1/",
+		"http://0.0.0.0/",
+		"http://rebound.tld/",
+	} {
+		if err := validateWebhookURL(raw, lookup); err == nil {
+			t.Errorf("Wanted %#v to be refused", raw)
+		}
+	}
+	if err := webhookDialControl("tcp", "10.1.2.3:80", nil); err == nil {
+		t.Errorf("Wanted dialing a private address to be refused")
+	}
+	if err := webhookDialControl("tcp", "8.8.8.8:443", nil); err != nil {
+		t.Errorf("Wanted dialing a public address to be allowed, but got %v", err)
+	}
+}
+
+func TestMessagesPageCursor(t *testing.T) {
+	at := time.Now()
+	messages := Messages{
+		Message{Id: []byte("a"), Public: true, CreatedAt: at},
+		Message{Id: []byte("c"), Public: true, CreatedAt: at},
+		Message{Id: []byte("d"), Public: true, CreatedAt: at.Add(time.Second)},
+		Message{Id: []byte("b"), Public: true, CreatedAt: at},
+	}
+	req := &MessagesRequest{Limit: 2}
+	found := []string{}
+	for {
+		page := req.page(&Game{}, nil, nil, append(Messages{}, messages...))
+		for _, message := range page {
+			found = append(found, string(message.Id))
+		}
+		if len(page) < req.Limit {
+			break
+		}
+		req.Before, req.BeforeId = page[len(page)-1].CreatedAt, page[len(page)-1].Id
+	}
+	if wanted := []string{"d", "c", "b", "a"}; !reflect.DeepEqual(found, wanted) {
+		t.Errorf("Wanted %v, but got %v", wanted, found)
+	}
+}
+
+func TestMutedByIgnoresBots(t *testing.T) {
+	members := Members{
+		Member{Id: []byte("a")},
+		Member{Id: []byte("b")},
+		Member{Id: []byte("c"), Bot: "random"},
+		Member{Id: []byte("d"), Bot: "random"},
+	}
+	target := &members[0]
+	members[1].MuteVotes = map[string]bool{target.Id.String(): true}
+	if !members.mutedBy(target) {
+		t.Errorf("Wanted the only other human to be able to mute %v", target.Id)
+	}
+	members[1].MuteVotes = nil
+	if members.mutedBy(target) {
+		t.Errorf("Wanted %v to be unmuted without votes", target.Id)
+	}
+}
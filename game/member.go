@@ -16,6 +16,9 @@ type Member struct {
 	UserId kol.Id `kol:"index"`
 	GameId kol.Id `kol:"index"`
 
+	// Bot is the kind of bot playing this member, which then has no user
+	Bot string
+
 	Nation           dip.Nation
 	PreferredNations []dip.Nation
 
@@ -24,6 +27,15 @@ type Member struct {
 	Committed bool
 	NoOrders  bool
 	NoWait    bool
+	// Autopilot makes a bot play the member until it gives orders or commits again
+	Autopilot bool
+
+	PauseVote     bool
+	ResumeVote    bool
+	ExtensionVote Minutes
+
+	MuteVotes map[string]bool
+	Muted     bool
 
 	CreatedAt time.Time
 	UpdatedAt time.Time
@@ -45,16 +57,50 @@ func (self Members) Swap(i, j int) {
 
 func (self Members) Get(email string) *Member {
 	for index, _ := range self {
-		if string(self[index].UserId) == email {
+		if self[index].Bot == "" && string(self[index].UserId) == email {
+			return &self[index]
+		}
+	}
+	return nil
+}
+
+/*
+GetNation returns the member of email playing nation, or the first member of email if nation is empty.
+*/
+func (self Members) GetNation(email string, nation dip.Nation) *Member {
+	if nation == "" {
+		return self.Get(email)
+	}
+	for index, _ := range self {
+		if self[index].Bot == "" && string(self[index].UserId) == email && self[index].Nation == nation {
 			return &self[index]
 		}
 	}
 	return nil
 }
 
+/*
+Nations returns the nations email plays, which are more than one for the owner of a hot seat practice game.
+*/
+func (self Members) Nations(email string) (result []dip.Nation) {
+	for _, member := range self {
+		if member.Bot == "" && string(member.UserId) == email && member.Nation != "" {
+			result = append(result, member.Nation)
+		}
+	}
+	return
+}
+
+func (self Members) viewerNations(viewer *Member) []dip.Nation {
+	if viewer == nil {
+		return nil
+	}
+	return self.Nations(string(viewer.UserId))
+}
+
 func (self Members) Contains(email string) bool {
 	for _, member := range self {
-		if string(member.UserId) == email {
+		if member.Bot == "" && string(member.UserId) == email {
 			return true
 		}
 	}
@@ -65,7 +111,7 @@ func (self Members) ToStates(d *kol.DB, g *Game, email string, isAdmin bool) (re
 	result = make([]MemberState, len(self))
 	isMember := false
 	for _, member := range self {
-		if member.UserId.Equals(kol.Id(email)) {
+		if member.Bot == "" && member.UserId.Equals(kol.Id(email)) {
 			isMember = true
 			break
 		}
@@ -83,7 +129,8 @@ func (self Members) ToStates(d *kol.DB, g *Game, email string, isAdmin bool) (re
 func (self *Member) ToState(d *kol.DB, g *Game, email string, isMember bool, isAdmin bool) (result *MemberState, err error) {
 	result = &MemberState{
 		Member: &Member{
-			Id: self.Id,
+			Id:  self.Id,
+			Bot: self.Bot,
 		},
 		User: &user.User{},
 	}
@@ -102,10 +149,29 @@ func (self *Member) ToState(d *kol.DB, g *Game, email string, isMember bool, isA
 		panic(fmt.Errorf("Unknown game state for %+v", g))
 	}
 	secretNation, secretEmail, secretNickname = g.SecretNation&flag == flag, g.SecretEmail&flag == flag, g.SecretNickname&flag == flag
-	isMe := string(self.UserId) == email
+	isMe := self.Bot == "" && string(self.UserId) == email
 	if isAdmin || isMe || !secretNation {
 		result.Member.Nation = self.Nation
 	}
+	if isAdmin || isMember {
+		result.Member.PauseVote = self.PauseVote
+		result.Member.ResumeVote = self.ResumeVote
+		result.Member.ExtensionVote = self.ExtensionVote
+		result.Member.Muted = self.Muted
+		result.Member.Autopilot = self.Autopilot
+	}
+	if isAdmin || isMe {
+		result.Member.MuteVotes = self.MuteVotes
+	}
+	if self.Bot != "" {
+		result.User.Nickname = botNames[self.Bot]
+		if isAdmin {
+			result.Member.Committed = self.Committed
+			result.Member.Options = self.Options
+			result.Member.NoOrders = self.NoOrders
+		}
+		return
+	}
 	if isAdmin || isMe || !secretEmail || !secretNickname {
 		foundUser := &user.User{Id: self.UserId}
 		if err = d.Get(foundUser); err != nil {
@@ -117,6 +183,7 @@ func (self *Member) ToState(d *kol.DB, g *Game, email string, isMember bool, isA
 		if isAdmin || (isMe || !secretNickname) {
 			result.User.Nickname = foundUser.Nickname
 		}
+		result.User.Bot = foundUser.Bot
 		if isAdmin || isMe {
 			result.Member.Committed = self.Committed
 			result.Member.Options = self.Options
@@ -166,7 +233,41 @@ func (self *Member) Created(d *kol.DB) {
 	d.EmitUpdate(&g)
 }
 
+/*
+mutedBy returns whether a majority of the human members, not counting target, vote to mute target.
+*/
+func (self Members) mutedBy(target *Member) bool {
+	voters := 0
+	votes := 0
+	for _, member := range self {
+		if member.Bot != "" || member.Id.Equals(target.Id) {
+			continue
+		}
+		voters++
+		if member.MuteVotes[target.Id.String()] {
+			votes++
+		}
+	}
+	return votes*2 > voters
+}
+
+/*
+UpdateMuted mutes the member if more than half of the other human members have voted to mute it, and unmutes it otherwise.
+*/
+func (self Members) UpdateMuted(d *kol.DB, target *Member) (err error) {
+	if muted := self.mutedBy(target); muted != target.Muted {
+		target.Muted = muted
+		if err = d.Set(target); err != nil {
+			return
+		}
+	}
+	return
+}
+
 func (self *Member) ReliabilityDelta(d *kol.DB, i int) (err error) {
+	if self.Bot != "" {
+		return
+	}
 	user := &user.User{Id: self.UserId}
 	if err = d.Get(user); err != nil {
 		return
@@ -188,12 +289,15 @@ func (self Members) Disallows(d *kol.DB, asking *user.User) (result bool, err er
 		return
 	}
 	for _, member := range self {
-		if askerList[member.UserId.String()] {
+		if member.Bot == "" && askerList[member.UserId.String()] {
 			result = true
 			return
 		}
 	}
 	for _, member := range self {
+		if member.Bot != "" {
+			continue
+		}
 		memberUser := &user.User{Id: member.UserId}
 		if err = d.Get(memberUser); err != nil {
 			return
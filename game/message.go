@@ -14,6 +14,7 @@ import (
 
 	"github.com/jhillyerd/go.enmime"
 	"github.com/zond/diplicity/common"
+	"github.com/zond/diplicity/epoch"
 	"github.com/zond/diplicity/user"
 	"github.com/zond/gmail"
 	dip "github.com/zond/godip/common"
@@ -73,6 +74,9 @@ func (self Messages) Len() int {
 }
 
 func (self Messages) Less(j, i int) bool {
+	if self[i].CreatedAt.Equal(self[j].CreatedAt) {
+		return bytes.Compare(self[i].Id, self[j].Id) < 0
+	}
 	return self[i].CreatedAt.Before(self[j].CreatedAt)
 }
 
@@ -81,20 +85,31 @@ func (self Messages) Swap(i, j int) {
 }
 
 type Message struct {
-	Id           kol.Id
-	GameId       kol.Id `kol:"index"`
-	SenderId     kol.Id
-	RecipientIds map[string]bool
-	SeenBy       map[string]bool
-	Public       bool
-
-	Body string
+	Id            kol.Id
+	GameId        kol.Id `kol:"index"`
+	SenderId      kol.Id
+	RecipientIds  map[string]bool
+	SeenBy        map[string]bool
+	Public        bool
+	SpectatorChat bool
+	Grey          bool
+	ChannelKey    string `kol:"index"`
+
+	Body  string
+	Press *Press
 
 	CreatedAt time.Time
 	UpdatedAt time.Time
 }
 
+const (
+	SpectatorChannelId = "spectators"
+)
+
 func (self *Message) ChannelId() string {
+	if self.SpectatorChat {
+		return SpectatorChannelId
+	}
 	recips := make(sort.StringSlice, 0, len(self.RecipientIds))
 	for recipientId, _ := range self.RecipientIds {
 		recips = append(recips, recipientId)
@@ -103,6 +118,53 @@ func (self *Message) ChannelId() string {
 	return strings.Join(recips, ".")
 }
 
+func ChannelKey(gameId kol.Id, channelId string) string {
+	return fmt.Sprintf("%v/%v", gameId, channelId)
+}
+
+/*
+before returns whether the message comes before the one created at createdAt with id in messages sorted newest first.
+*/
+func (self *Message) before(createdAt time.Time, id kol.Id) bool {
+	if self.CreatedAt.Equal(createdAt) {
+		return len(id) > 0 && bytes.Compare(self.Id, id) < 0
+	}
+	return self.CreatedAt.Before(createdAt)
+}
+
+func (self *Message) VisibleTo(game *Game, member *Member, spectator *Spectator) bool {
+	if self.SpectatorChat {
+		return game.State == common.GameStateEnded || (member == nil && spectator != nil)
+	}
+	return self.Public || (member != nil && (self.RecipientIds[member.Id.String()] || self.SenderId.Equals(member.Id)))
+}
+
+/*
+redact hides the sender of grey messages from everyone but the sender.
+*/
+func (self *Message) redact(viewer *Member) *Message {
+	if !self.Grey || (viewer != nil && self.SenderId.Equals(viewer.Id)) {
+		return self
+	}
+	result := *self
+	result.SenderId = nil
+	result.SeenBy = map[string]bool{}
+	if viewer != nil && self.SeenBy[viewer.Id.String()] {
+		result.SeenBy[viewer.Id.String()] = true
+	}
+	return &result
+}
+
+func (self *Message) Matches(query string) bool {
+	body := strings.ToLower(self.Body)
+	for _, word := range strings.Fields(strings.ToLower(query)) {
+		if !strings.Contains(body, word) {
+			return false
+		}
+	}
+	return true
+}
+
 func (self *Message) Updated(d *kol.DB, old *Message) {
 	g := Game{Id: self.GameId}
 	if err := d.Get(&g); err != nil {
@@ -185,16 +247,19 @@ func (self *Message) Send(c common.SkinnyContext, game *Game, sender *Member) (e
 		return
 	}
 
-	// make sure the sender is one of the recipients
-	self.RecipientIds[sender.Id.String()] = true
+	// make sure the sender is one of the recipients, unless the sender wants to be anonymous
+	if !self.Grey {
+		self.RecipientIds[sender.Id.String()] = true
+	}
 
 	// The sender but nobody else saw it...
 	self.SeenBy = map[string]bool{
 		sender.Id.String(): true,
 	}
 
-	// See what phase type the game is in
+	// See what phase type and year the game is in
 	var phaseType dip.PhaseType
+	year := 0
 	switch game.State {
 	case common.GameStateCreated:
 		phaseType = common.BeforeGamePhaseType
@@ -204,6 +269,21 @@ func (self *Message) Send(c common.SkinnyContext, game *Game, sender *Member) (e
 			return
 		}
 		phaseType = phase.Type
+		year = phase.Year
+		// Check that the press window hasn't closed before the deadline, if the phase has a deadline that is counting down
+		if game.PressCloseBefore > 0 && phase.Deadline != 0 && !game.Paused {
+			var ep time.Duration
+			if ep, err = epoch.Get(c.DB()); err != nil {
+				return
+			}
+			if phase.Deadline-ep < time.Minute*time.Duration(game.PressCloseBefore) {
+				err = IllegalMessageError{
+					Description: fmt.Sprintf("%+v does not allow %+v this close to the deadline of %+v", game, self, phase),
+					Phrase:      "Press is closed until the next phase",
+				}
+				return
+			}
+		}
 	case common.GameStateEnded:
 		phaseType = common.AfterGamePhaseType
 	default:
@@ -211,8 +291,8 @@ func (self *Message) Send(c common.SkinnyContext, game *Game, sender *Member) (e
 		return
 	}
 
-	// Find what chats are allowed during this phase type
-	allowedFlags := game.ChatFlags[phaseType]
+	// Find what chats are allowed during this phase type and year
+	allowedFlags := game.chatFlags(phaseType, year)
 
 	// load game members
 	members, err := game.Members(c.DB())
@@ -220,9 +300,21 @@ func (self *Message) Send(c common.SkinnyContext, game *Game, sender *Member) (e
 		return
 	}
 
-	// See if the recipient count is allowed
+	// See if grey press is allowed
+	if self.Grey && (allowedFlags&common.ChatGrey) == 0 {
+		err = IllegalMessageError{
+			Description: fmt.Sprintf("%+v does not allow grey press %+v during %+v", game, self, phaseType),
+			Phrase:      "This kind of message is not allowed at this stage of the game",
+		}
+		return
+	}
+
+	// See if the recipient count is allowed, counting anonymous senders as recipients
 	recipients := len(self.RecipientIds)
-	if self.Public || recipients == len(common.VariantMap[game.Variant].Nations) {
+	if self.Grey && !self.RecipientIds[sender.Id.String()] {
+		recipients++
+	}
+	if self.Public || recipients == len(common.VariantMap[game.Variant].Nations) || (game.State != common.GameStateCreated && recipients == len(members)) {
 		if (allowedFlags & common.ChatConference) == 0 {
 			err = IllegalMessageError{
 				Description: fmt.Sprintf("%+v does not allow %+v during %+v", game, self, phaseType),
@@ -230,6 +322,13 @@ func (self *Message) Send(c common.SkinnyContext, game *Game, sender *Member) (e
 			}
 			return
 		}
+		if sender.Muted {
+			err = IllegalMessageError{
+				Description: fmt.Sprintf("%+v is muted in %+v", sender, game),
+				Phrase:      "You have been muted by the other members of this game",
+			}
+			return
+		}
 		self.Public = true
 		for _, memb := range members {
 			self.RecipientIds[memb.Id.String()] = true
@@ -255,10 +354,25 @@ func (self *Message) Send(c common.SkinnyContext, game *Game, sender *Member) (e
 		return
 	}
 
+	self.ChannelKey = ChannelKey(game.Id, self.ChannelId())
 	if err = c.DB().Set(self); err != nil {
 		return
 	}
 
+	if self.Public {
+		senderName := string(common.Anonymous)
+		if !self.Grey {
+			senderName = sender.ShortName(game, senderUser)
+		}
+		if err = fireWebhooks(c, game, WebhookPublicMessage, webhookMessage{
+			MessageId: self.Id,
+			Sender:    senderName,
+			Body:      self.Body,
+		}); err != nil {
+			return
+		}
+	}
+
 	recipNations := sort.StringSlice{}
 	for memberId, _ := range self.RecipientIds {
 		for _, member := range members {
@@ -273,16 +387,26 @@ func (self *Message) Send(c common.SkinnyContext, game *Game, sender *Member) (e
 	recipName := strings.Join(recipNations, ", ")
 	for memberId, _ := range self.RecipientIds {
 		for _, member := range members {
-			if memberId == member.Id.String() && self.SenderId.String() != memberId {
+			if memberId == member.Id.String() && self.SenderId.String() != memberId && member.Bot == "" {
 				user := &user.User{Id: member.UserId}
 				if err = c.DB().Get(user); err != nil {
 					c.Errorf("Trying to load user %#v: %v", member.UserId.String(), err)
 					return
 				}
-				if !user.MessageEmailDisabled {
+				if !user.MessageEmailDisabled && !user.Bot {
 					subKey := fmt.Sprintf("/games/%v/messages", game.Id)
 					if !c.IsSubscribing(user.Email, subKey, common.SubscriptionTimeout) {
-						if err = self.emailTo(c, game, sender, senderUser, &member, user, recipName); err != nil {
+						if user.Digest {
+							if err = c.DB().Set(&DigestEntry{
+								UserId:    user.Id,
+								GameId:    game.Id,
+								MemberId:  member.Id,
+								MessageId: self.Id,
+							}); err != nil {
+								return
+							}
+							c.Infof("Not sending to %#v, added to digest", user.Email)
+						} else if err = self.emailTo(c, game, sender, senderUser, &member, user, recipName); err != nil {
 							c.Errorf("Failed sending to %#v: %v", user.Id.String(), err)
 							return
 						}
@@ -299,6 +423,22 @@ func (self *Message) Send(c common.SkinnyContext, game *Game, sender *Member) (e
 	return
 }
 
+/*
+SendToSpectators stores a message in the spectator channel, which members can't see until the game has ended.
+*/
+func (self *Message) SendToSpectators(c common.SkinnyContext, game *Game, senderId kol.Id) (err error) {
+	c.Debugf("Sending %#v from spectator %#v in %#v", self.Body, senderId.String(), game.Id.String())
+	self.SenderId = senderId
+	self.SpectatorChat = true
+	self.Public = false
+	self.RecipientIds = map[string]bool{}
+	self.SeenBy = map[string]bool{
+		senderId.String(): true,
+	}
+	self.ChannelKey = ChannelKey(game.Id, self.ChannelId())
+	return c.DB().Set(self)
+}
+
 func (self *Message) emailTo(c common.SkinnyContext, game *Game, sender *Member, senderUser *user.User, recip *Member, recipUser *user.User, recipName string) (err error) {
 	mailTag := &MailTag{
 		M: self.Id,
@@ -330,6 +470,9 @@ func (self *Message) emailTo(c common.SkinnyContext, game *Game, sender *Member,
 		}
 	}
 	senderName := sender.ShortName(game, senderUser)
+	if self.Grey {
+		senderName = string(common.Anonymous)
+	}
 	replyTo := fmt.Sprintf("%v+%v@%v", parts[0], encodedMailTag, parts[1])
 	to := fmt.Sprintf("%v <%v>", recipName, recipUser.Email)
 	memberIds := []string{}
@@ -337,19 +480,29 @@ func (self *Message) emailTo(c common.SkinnyContext, game *Game, sender *Member,
 		memberIds = append(memberIds, memberId)
 	}
 	sort.Sort(sort.StringSlice(memberIds))
-	contextLink, err := recipUser.I("To see this message in context: http://%v/games/%v/messages/%v", recipUser.DiplicityHost, self.GameId, self.ChannelId())
+	subject, err := game.Describe(c, recipUser)
 	if err != nil {
 		return
 	}
-	unsubLink, err := recipUser.I("To unsubscribe: http://%v/unsubscribe/%v", recipUser.DiplicityHost, encodedUnsubTag)
-	if err != nil {
-		return
+	mail := &common.Mail{
+		FromName:    senderName,
+		ReplyTo:     replyTo,
+		To:          []string{to},
+		Subject:     subject,
+		Unsubscribe: fmt.Sprintf("http://%v/unsubscribe/%v", recipUser.DiplicityHost, encodedUnsubTag),
 	}
-	body := fmt.Sprintf(common.EmailTemplate, self.Body, contextLink, unsubLink)
-	subject, err := game.Describe(c, recipUser)
-	if err != nil {
+	if err = c.RenderMail(mail, "message", recipUser.Language, common.MailData{
+		Translator:     recipUser,
+		Host:           recipUser.DiplicityHost,
+		GameId:         self.GameId.String(),
+		ChannelId:      self.ChannelId(),
+		Body:           self.Body,
+		UnsubscribeTag: encodedUnsubTag,
+	}); err != nil {
 		return
 	}
-	go c.SendMail(senderName, replyTo, subject, body, []string{to})
+	if err := c.SendMessage(mail); err != nil {
+		c.Errorf("Failed queueing %#v to %v: %v", mail.Subject, mail.To, err)
+	}
 	return
 }
@@ -2,6 +2,7 @@ package game
 
 import (
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/zond/diplicity/common"
@@ -14,6 +15,10 @@ import (
 	"github.com/zond/kcwraps/kol"
 )
 
+// The pending auto resolution of each phase, so that rescheduling replaces it instead of piling up timers
+var resolutionTimers = map[string]*time.Timer{}
+var resolutionTimersLock = sync.Mutex{}
+
 func ScheduleUnresolvedPhases(c common.SkinnyContext) (err error) {
 	unresolved := Phases{}
 	if err = c.DB().Query().Where(kol.Equals{"Resolved", false}).All(&unresolved); err != nil {
@@ -69,6 +74,27 @@ func (self *Phase) autoResolve(c common.SkinnyContext) (err error) {
 			err = fmt.Errorf("While trying to load %+v's game: %v", self, err)
 			return
 		}
+		if game.Paused {
+			c.Infof("%+v is paused", game)
+			return
+		}
+		var ep time.Duration
+		if ep, err = epoch.Get(c.DB()); err != nil {
+			return
+		}
+		if self.Deadline > ep {
+			c.Infof("%+v has been extended, rescheduling", self)
+			return self.Schedule(c)
+		}
+		if game.VacationPolicy == common.VacationExtend {
+			var extended bool
+			if extended, err = game.extendForVacations(c, self); err != nil {
+				return
+			}
+			if extended {
+				return self.Schedule(c)
+			}
+		}
 		return game.resolve(c, self)
 	}); err != nil {
 		return
@@ -77,7 +103,7 @@ func (self *Phase) autoResolve(c common.SkinnyContext) (err error) {
 }
 
 func (self *Phase) Schedule(c common.SkinnyContext) error {
-	if !self.Resolved {
+	if !self.Resolved && self.Deadline != 0 {
 		ep, err := epoch.Get(c.DB())
 		if err != nil {
 			return err
@@ -85,11 +111,24 @@ func (self *Phase) Schedule(c common.SkinnyContext) error {
 		timeout := self.Deadline - ep
 		c.BetweenTransactions(func(c common.SkinnyContext) {
 			if timeout > 0 {
-				time.AfterFunc(timeout, func() {
+				key := self.Id.String()
+				var timer *time.Timer
+				timer = time.AfterFunc(timeout, func() {
+					resolutionTimersLock.Lock()
+					if resolutionTimers[key] == timer {
+						delete(resolutionTimers, key)
+					}
+					resolutionTimersLock.Unlock()
 					if err := self.autoResolve(c); err != nil {
 						c.Errorf("Failed resolving %+v after %v: %v", self, timeout, err)
 					}
 				})
+				resolutionTimersLock.Lock()
+				if old, found := resolutionTimers[key]; found {
+					old.Stop()
+				}
+				resolutionTimers[key] = timer
+				resolutionTimersLock.Unlock()
 				c.Debugf("Scheduled resolution of %v/%v in %v at %v", self.GameId, self.Id, timeout, time.Now().Add(timeout))
 			} else {
 				c.Debugf("Resolving %v/%v immediately, it is %v overdue", self.GameId, self.Id, -timeout)
@@ -113,41 +152,60 @@ func (self *Phase) emailTo(c common.SkinnyContext, game *Game, member *Member, u
 	if err != nil {
 		return
 	}
-	contextLink, err := user.I("To see this in context: http://%v/games/%v", user.DiplicityHost, self.GameId)
+	subject, err := game.Describe(c, user)
 	if err != nil {
 		return
 	}
-	unsubLink, err := user.I("To unsubscribe: http://%v/unsubscribe/%v", user.DiplicityHost, encodedUnsubTag)
-	if err != nil {
-		return
+	mail := &common.Mail{
+		FromName:    "diplicity",
+		ReplyTo:     c.ReceiveAddress(),
+		To:          []string{to},
+		Subject:     subject,
+		Unsubscribe: fmt.Sprintf("http://%v/unsubscribe/%v", user.DiplicityHost, encodedUnsubTag),
 	}
-	text, err := user.I("A new phase has been created")
-	if err != nil {
+	if err = c.RenderMail(mail, "phase", user.Language, common.MailData{
+		Translator:     user,
+		Host:           user.DiplicityHost,
+		GameId:         self.GameId.String(),
+		UnsubscribeTag: encodedUnsubTag,
+	}); err != nil {
 		return
 	}
-	subject, err := game.Describe(c, user)
-	if err != nil {
-		return
+	if err := c.SendMessage(mail); err != nil {
+		c.Errorf("Failed queueing %#v to %v: %v", mail.Subject, mail.To, err)
 	}
-	body := fmt.Sprintf(common.EmailTemplate, text, contextLink, unsubLink)
-	go c.SendMail("diplicity", c.ReceiveAddress(), subject, body, []string{to})
 	return
 }
 
 func (self *Phase) SendStartedEmails(c common.SkinnyContext, game *Game) (err error) {
+	if game.Practice {
+		return
+	}
 	members, err := game.Members(c.DB())
 	if err != nil {
 		return
 	}
 	for _, member := range members {
+		if member.Bot != "" {
+			continue
+		}
 		user := &user.User{Id: member.UserId}
 		if err = c.DB().Get(user); err != nil {
 			return
 		}
-		if !user.PhaseEmailDisabled {
+		if !user.PhaseEmailDisabled && !user.Bot {
 			subKey := fmt.Sprintf("/games/%v", game.Id)
 			if !c.IsSubscribing(user.Email, subKey, common.SubscriptionTimeout) {
-				if err = self.emailTo(c, game, &member, user); err != nil {
+				if user.Digest {
+					if err = c.DB().Set(&DigestEntry{
+						UserId:  user.Id,
+						GameId:  game.Id,
+						PhaseId: self.Id,
+					}); err != nil {
+						return
+					}
+					c.Infof("Not sending to %#v, added to digest", user.Email)
+				} else if err = self.emailTo(c, game, &member, user); err != nil {
 					c.Errorf("Failed sending to %#v: %v", user.Id.String(), err)
 					return
 				}
@@ -176,15 +234,19 @@ func (self *Phase) Updated(d *kol.DB, old *Phase) {
 	d.EmitUpdate(&g)
 }
 
-func (self *Phase) redact(member *Member) *Phase {
+/*
+redact hides the orders of an unresolved phase from everyone but the nations giving them.
+*/
+func (self *Phase) redact(nations ...dip.Nation) *Phase {
 	if self == nil {
 		return nil
 	}
 	result := *self
 	if !self.Resolved {
-		for nat, _ := range self.Orders {
-			if member == nil || member.Nation != nat {
-				delete(result.Orders, nat)
+		result.Orders = map[dip.Nation]map[dip.Province][]string{}
+		for _, nat := range nations {
+			if orders, found := self.Orders[nat]; found {
+				result.Orders[nat] = orders
 			}
 		}
 	}
@@ -0,0 +1,93 @@
+package game
+
+import (
+	"fmt"
+
+	"github.com/zond/diplicity/common"
+	"github.com/zond/kcwraps/kol"
+)
+
+/*
+fillWithOwner lets the owner of the game take all seats not taken by the members of the game, for hot seat practice games.
+*/
+func (self *Game) fillWithOwner(c common.SkinnyContext) (err error) {
+	members, err := self.Members(c.DB())
+	if err != nil {
+		return
+	}
+	for i := len(members); i < len(common.VariantMap[self.Variant].Nations); i++ {
+		if err = c.DB().Set(&Member{
+			GameId: self.Id,
+			UserId: self.OwnerId,
+		}); err != nil {
+			return
+		}
+	}
+	return
+}
+
+type RollbackRequest struct {
+	GameId  kol.Id
+	Ordinal int
+	Reason  string
+}
+
+/*
+PracticeRollback lets the owner of a practice game go back to an earlier phase, the way AdminRollback does.
+*/
+func PracticeRollback(c common.WSContext) (result interface{}, err error) {
+	req := RollbackRequest{}
+	c.Data().Overwrite(&req)
+	err = c.Transact(func(c common.WSContext) (err error) {
+		game := &Game{Id: req.GameId}
+		if err = c.DB().Get(game); err != nil {
+			return
+		}
+		if !game.Practice {
+			err = fmt.Errorf("%v is not a practice game", game.Id)
+			return
+		}
+		if !game.OwnerId.Equals(kol.Id(c.Principal())) {
+			err = fmt.Errorf("Only the owner can roll back %v", game.Id)
+			return
+		}
+		if game.State == common.GameStateCreated {
+			err = fmt.Errorf("%v is not started", game.Id)
+			return
+		}
+		return game.rollback(c.Diet(), req.Ordinal, kol.Id(c.Principal()), req.Reason)
+	})
+	return
+}
+
+type RestoreRequest struct {
+	RollbackId kol.Id
+}
+
+/*
+PracticeRestore lets the owner of a practice game undo a PracticeRollback, the way AdminRestoreRollback does.
+*/
+func PracticeRestore(c common.WSContext) (result interface{}, err error) {
+	req := RestoreRequest{}
+	c.Data().Overwrite(&req)
+	err = c.Transact(func(c common.WSContext) (err error) {
+		rollback := &Rollback{Id: req.RollbackId}
+		if err = c.DB().Get(rollback); err != nil {
+			return
+		}
+		game := &Game{Id: rollback.GameId}
+		if err = c.DB().Get(game); err != nil {
+			return
+		}
+		if !game.Practice {
+			err = fmt.Errorf("%v is not a practice game", game.Id)
+			return
+		}
+		if !game.OwnerId.Equals(kol.Id(c.Principal())) {
+			err = fmt.Errorf("Only the owner can restore %v", game.Id)
+			return
+		}
+		return game.restore(c.Diet(), rollback)
+	})
+	return
+}
@@ -25,8 +25,9 @@ func permutations(nations []dip.Nation) (result [][]dip.Nation) {
 }
 
 func preferencesScore(perm []dip.Nation, preferences [][]dip.Nation) (result int) {
-	for index, chosen := range perm {
-		for at, nation := range preferences[index] {
+	for index, preference := range preferences {
+		chosen := perm[index]
+		for at, nation := range preference {
 			if nation == chosen {
 				result += at * at
 				break
@@ -44,5 +45,6 @@ func optimizePreferences(preferences [][]dip.Nation) (result []dip.Nation) {
 			result = perm
 		}
 	}
+	result = result[:len(preferences)]
 	return
 }
@@ -0,0 +1,90 @@
+package game
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/zond/godip/classical/orders"
+	dip "github.com/zond/godip/common"
+	"github.com/zond/kcwraps/kol"
+)
+
+const (
+	PressPropose  = "Propose"
+	PressAccept   = "Accept"
+	PressReject   = "Reject"
+	PressCancel   = "Cancel"
+	PressPeace    = "Peace"
+	PressAlliance = "Alliance"
+	PressDraw     = "Draw"
+)
+
+var pressTypes = map[string]bool{
+	PressPropose:  true,
+	PressAccept:   true,
+	PressReject:   true,
+	PressCancel:   true,
+	PressPeace:    true,
+	PressAlliance: true,
+	PressDraw:     true,
+}
+
+/*
+Press is the structured content of a message, for bots that can't read the body.
+
+Propose suggests the Orders, and Peace, Alliance and Draw suggest an agreement between the Nations.
+Accept, Reject and Cancel answer or withdraw the press of the message with the Reference id.
+*/
+type Press struct {
+	Type      string
+	Reference kol.Id
+	Nations   []dip.Nation
+	Orders    [][]string
+}
+
+func (self *Press) validate() (err error) {
+	if !pressTypes[self.Type] {
+		err = fmt.Errorf("Unknown press type %#v", self.Type)
+		return
+	}
+	switch self.Type {
+	case PressAccept, PressReject, PressCancel:
+		if len(self.Reference) == 0 {
+			err = fmt.Errorf("%v press needs a reference", self.Type)
+			return
+		}
+	case PressPropose:
+		if len(self.Orders) == 0 {
+			err = fmt.Errorf("%v press needs orders", self.Type)
+			return
+		}
+	default:
+		if len(self.Nations) == 0 {
+			err = fmt.Errorf("%v press needs nations", self.Type)
+			return
+		}
+	}
+	for _, order := range self.Orders {
+		if _, err = orders.Parse(order); err != nil {
+			return
+		}
+	}
+	return
+}
+
+/*
+String renders the press as a message body for humans.
+*/
+func (self *Press) String() string {
+	parts := []string{self.Type}
+	if len(self.Reference) > 0 {
+		parts = append(parts, self.Reference.String())
+	}
+	for _, nation := range self.Nations {
+		parts = append(parts, string(nation))
+	}
+	for _, order := range self.Orders {
+		parts = append(parts, strings.Join(order, " "))
+	}
+	return strings.Join(parts, "\n")
+}
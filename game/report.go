@@ -0,0 +1,37 @@
+package game
+
+import (
+	"time"
+
+	"github.com/zond/kcwraps/kol"
+)
+
+type Report struct {
+	Id         kol.Id
+	GameId     kol.Id `kol:"index"`
+	MessageId  kol.Id `kol:"index"`
+	ReporterId kol.Id
+	Reason     string
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+type Reports []Report
+
+func (self Reports) Len() int {
+	return len(self)
+}
+
+func (self Reports) Less(j, i int) bool {
+	return self[i].CreatedAt.Before(self[j].CreatedAt)
+}
+
+func (self Reports) Swap(i, j int) {
+	self[i], self[j] = self[j], self[i]
+}
+
+type ReportState struct {
+	*Report
+	Message *Message
+}
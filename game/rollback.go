@@ -0,0 +1,293 @@
+package game
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/zond/diplicity/common"
+	"github.com/zond/diplicity/epoch"
+	"github.com/zond/diplicity/user"
+	dip "github.com/zond/godip/common"
+	"github.com/zond/kcwraps/kol"
+)
+
+/*
+Rollback archives what a rollback of a game discarded, so that it can be inspected or restored.
+
+Target is the phase rolled back to as it was before the rollback, and Phases are the phases after it.
+*/
+type Rollback struct {
+	Id        kol.Id
+	GameId    kol.Id `kol:"index"`
+	ActorId   kol.Id
+	Reason    string
+	Ordinal   int
+	GameState common.GameState
+	EndReason common.EndReason
+	Target    Phase
+	Phases    Phases
+	Restored  bool
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+type Rollbacks []Rollback
+
+func (self Rollbacks) Len() int {
+	return len(self)
+}
+
+func (self Rollbacks) Less(i, j int) bool {
+	return self[i].CreatedAt.Before(self[j].CreatedAt)
+}
+
+func (self Rollbacks) Swap(i, j int) {
+	self[i], self[j] = self[j], self[i]
+}
+
+/*
+latest returns the last phase the game had before the rollback.
+*/
+func (self *Rollback) latest() (result Phase) {
+	result = self.Target
+	for _, phase := range self.Phases {
+		if phase.Ordinal > result.Ordinal {
+			result = phase
+		}
+	}
+	return
+}
+
+func (self *Game) Rollbacks(d *kol.DB) (result Rollbacks, err error) {
+	if err = d.Query().Where(kol.Equals{"GameId", self.Id}).All(&result); err != nil {
+		return
+	}
+	sort.Sort(result)
+	return
+}
+
+/*
+reopen makes phase the current, unresolved, phase of the game, with a new deadline and all members having to commit again.
+*/
+func (self *Game) reopen(c common.SkinnyContext, phase *Phase) (err error) {
+	ep, err := epoch.Get(c.DB())
+	if err != nil {
+		return
+	}
+	members, err := self.Members(c.DB())
+	if err != nil {
+		return
+	}
+	phase.Resolutions = map[dip.Province]string{}
+	phase.Resolved = false
+	if phase.Deadline, err = self.deadline(ep, phase.Type); err != nil {
+		return
+	}
+	for index, _ := range members {
+		opts := dip.Options{}
+		if opts, err = phase.Options(members[index].Nation); err != nil {
+			return
+		}
+		members[index].Options = opts
+		if len(opts) == 0 {
+			members[index].Committed = true
+			members[index].NoOrders = true
+		} else {
+			members[index].Committed = false
+			members[index].NoOrders = false
+		}
+		if err = c.DB().Set(&members[index]); err != nil {
+			return
+		}
+	}
+	if err = self.playBots(c, phase, members); err != nil {
+		return
+	}
+	if err = c.DB().Set(phase); err != nil {
+		return
+	}
+	return phase.Schedule(c)
+}
+
+/*
+splitPhases returns the phase with ordinal, and the phases after it oldest first.
+*/
+func splitPhases(phases Phases, ordinal int) (target *Phase, later Phases) {
+	later = Phases{}
+	for index, _ := range phases {
+		if phases[index].Ordinal == ordinal {
+			target = &phases[index]
+		} else if phases[index].Ordinal > ordinal {
+			later = append(later, phases[index])
+		}
+	}
+	// Phases sort newest first
+	sort.Sort(sort.Reverse(later))
+	return
+}
+
+/*
+rollback makes the phase with ordinal the current, unresolved, phase again, and archives and deletes all phases after it.
+*/
+func (self *Game) rollback(c common.SkinnyContext, ordinal int, actor kol.Id, reason string) (err error) {
+	phases, err := self.Phases(c.DB())
+	if err != nil {
+		return
+	}
+	target, later := splitPhases(phases, ordinal)
+	if target == nil {
+		err = fmt.Errorf("No phase with ordinal %v in %v", ordinal, self.Id)
+		return
+	}
+	for index, _ := range later {
+		if err = c.DB().Del(&later[index]); err != nil {
+			return
+		}
+	}
+	archive := &Rollback{
+		GameId:    self.Id,
+		ActorId:   actor,
+		Reason:    reason,
+		Ordinal:   ordinal,
+		GameState: self.State,
+		EndReason: self.EndReason,
+		Target:    *target,
+		Phases:    later,
+	}
+	if err = c.DB().Set(archive); err != nil {
+		return
+	}
+	if self.State == common.GameStateEnded {
+		self.State = common.GameStateStarted
+		self.EndReason = ""
+		if err = c.DB().Set(self); err != nil {
+			return
+		}
+	}
+	if err = self.reopen(c, target); err != nil {
+		return
+	}
+	c.Infof("%v rolled %v back to %v because %#v", string(actor), self.Id, target.ShortString(), reason)
+	self.sendRollbackEmails(c, target, reason)
+	return
+}
+
+/*
+restore undoes rollback, as long as the game hasn't left the phase it was rolled back to.
+*/
+func (self *Game) restore(c common.SkinnyContext, rollback *Rollback) (err error) {
+	if rollback.Restored {
+		err = fmt.Errorf("%v is already restored", rollback.Id)
+		return
+	}
+	_, last, err := self.Phase(c.DB(), 0)
+	if err != nil {
+		return
+	}
+	if last == nil || last.Ordinal != rollback.Ordinal {
+		err = fmt.Errorf("%v has left the phase it was rolled back to", self.Id)
+		return
+	}
+	target := rollback.Target
+	if err = c.DB().Set(&target); err != nil {
+		return
+	}
+	for index, _ := range rollback.Phases {
+		if err = c.DB().Set(&rollback.Phases[index]); err != nil {
+			return
+		}
+	}
+	current := rollback.latest()
+	self.State = rollback.GameState
+	self.EndReason = rollback.EndReason
+	if err = c.DB().Set(self); err != nil {
+		return
+	}
+	if self.State == common.GameStateStarted && !current.Resolved {
+		if err = self.reopen(c, &current); err != nil {
+			return
+		}
+	}
+	rollback.Restored = true
+	if err = c.DB().Set(rollback); err != nil {
+		return
+	}
+	c.Infof("Restored %v to %v", self.Id, current.ShortString())
+	return
+}
+
+func (self *Game) sendRollbackEmails(c common.SkinnyContext, phase *Phase, reason string) {
+	if self.Practice {
+		return
+	}
+	members, err := self.Members(c.DB())
+	if err != nil {
+		c.Errorf("Failed loading members of %v: %v", self.Id, err)
+		return
+	}
+	sent := map[string]bool{}
+	for _, member := range members {
+		if member.Bot != "" || sent[member.UserId.String()] {
+			continue
+		}
+		sent[member.UserId.String()] = true
+		u := &user.User{Id: member.UserId}
+		if err = c.DB().Get(u); err != nil {
+			c.Errorf("Failed loading %v: %v", member.UserId, err)
+			continue
+		}
+		if u.PhaseEmailDisabled || u.Bot {
+			c.Infof("Not sending to %#v, phase email disabled", u.Email)
+			continue
+		}
+		if err = self.rollbackEmailTo(c, phase, reason, &member, u); err != nil {
+			c.Errorf("Failed sending to %#v: %v", u.Id.String(), err)
+		}
+	}
+}
+
+func (self *Game) rollbackEmailTo(c common.SkinnyContext, phase *Phase, reason string, member *Member, user *user.User) (err error) {
+	unsubTag := &common.UnsubscribeTag{
+		T: common.UnsubscribePhaseEmail,
+		U: user.Id,
+	}
+	unsubTag.H = unsubTag.Hash(c.Secret())
+	encodedUnsubTag, err := unsubTag.Encode()
+	if err != nil {
+		return
+	}
+	subject, err := user.I("The game has been rolled back to %v", phase.ShortString())
+	if err != nil {
+		return
+	}
+	body := subject
+	if reason != "" {
+		var because string
+		if because, err = user.I("Reason: %v", reason); err != nil {
+			return
+		}
+		body = fmt.Sprintf("%v\n%v", body, because)
+	}
+	mail := &common.Mail{
+		FromName:    "diplicity",
+		ReplyTo:     c.ReceiveAddress(),
+		To:          []string{fmt.Sprintf("%v <%v>", member.Nation, user.Email)},
+		Subject:     subject,
+		Unsubscribe: fmt.Sprintf("http://%v/unsubscribe/%v", user.DiplicityHost, encodedUnsubTag),
+	}
+	if err = c.RenderMail(mail, "rollback", user.Language, common.MailData{
+		Translator:     user,
+		Host:           user.DiplicityHost,
+		GameId:         self.Id.String(),
+		Body:           body,
+		UnsubscribeTag: encodedUnsubTag,
+	}); err != nil {
+		return
+	}
+	if err := c.SendMessage(mail); err != nil {
+		c.Errorf("Failed queueing %#v to %v: %v", mail.Subject, mail.To, err)
+	}
+	return
+}
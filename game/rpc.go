@@ -1,8 +1,10 @@
 package game
 
 import (
-	"encoding/base64"
 	"fmt"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/zond/diplicity/common"
 	"github.com/zond/godip/classical/orders"
@@ -16,14 +18,15 @@ func UncommitPhase(c common.WSContext) (result interface{}, err error) {
 	return
 }
 
+type SeeRequest struct {
+	MessageId kol.Id
+}
+
 func SeeMessage(c common.WSContext) (result interface{}, err error) {
-	messageId, err := base64.URLEncoding.DecodeString(c.Data().GetString("MessageId"))
-	if err != nil {
-		err = fmt.Errorf("Error trying to decode %#v: %v", c.Data().GetString("MessageId"), err)
-		return
-	}
+	req := SeeRequest{}
+	c.Data().Overwrite(&req)
 	err = c.Transact(func(c common.WSContext) (err error) {
-		message := &Message{Id: messageId}
+		message := &Message{Id: req.MessageId}
 		if err = c.DB().Get(message); err != nil {
 			return
 		}
@@ -54,14 +57,32 @@ func CommitPhase(c common.WSContext) (result interface{}, err error) {
 	return
 }
 
+/*
+CommitRequest identifies a phase, and the nation to commit in practice games where the caller plays more than one.
+*/
+type CommitRequest struct {
+	PhaseId kol.Id
+	GameId  kol.Id
+	Nation  dip.Nation
+}
+
+/*
+setPhaseCommitted commits or uncommits the caller in the phase with PhaseId, or in the current phase of the game with GameId if no PhaseId is given.
+*/
 func setPhaseCommitted(c common.WSContext, commit bool) (err error) {
-	phaseId, err := base64.URLEncoding.DecodeString(c.Data().GetString("PhaseId"))
-	if err != nil {
-		return
-	}
+	req := CommitRequest{}
+	c.Data().Overwrite(&req)
 	return c.Transact(func(c common.WSContext) (err error) {
-		phase := &Phase{Id: phaseId}
-		if err = c.DB().Get(phase); err != nil {
+		phase := &Phase{Id: req.PhaseId}
+		if len(req.PhaseId) == 0 {
+			if _, phase, err = (&Game{Id: req.GameId}).Phase(c.DB(), 0); err != nil {
+				return
+			}
+			if phase == nil {
+				err = kol.NotFound
+				return
+			}
+		} else if err = c.DB().Get(phase); err != nil {
 			return
 		}
 		game, err := phase.Game(c.DB())
@@ -72,7 +93,7 @@ func setPhaseCommitted(c common.WSContext, commit bool) (err error) {
 		if err != nil {
 			return
 		}
-		member := members.Get(c.Principal())
+		member := members.GetNation(c.Principal(), req.Nation)
 		if member == nil {
 			err = fmt.Errorf("Not member of game")
 			return
@@ -84,17 +105,16 @@ func setPhaseCommitted(c common.WSContext, commit bool) (err error) {
 		}
 		member.Committed = commit
 		member.NoWait = false
+		member.Autopilot = false
 		if err = c.DB().Set(member); err != nil {
 			return
 		}
-		if !phase.Resolved {
-			count := 0
-			for _, m := range members {
-				if m.Committed || m.NoWait {
-					count++
-				}
+		if !phase.Resolved && !game.Paused {
+			var done bool
+			if done, err = game.allDone(c.DB(), members); err != nil {
+				return
 			}
-			if count == len(members) {
+			if done {
 				if err = game.resolve(c.Diet(), phase); err != nil {
 					return
 				}
@@ -107,18 +127,32 @@ func setPhaseCommitted(c common.WSContext, commit bool) (err error) {
 	})
 }
 
+/*
+OrderRequest sets the order of the unit in the first province of Order, or removes it if Order is only the province.
+
+Nation picks the nation to give the order for in practice games where the caller plays more than one.
+*/
+type OrderRequest struct {
+	GameId kol.Id
+	Order  []string
+	Nation dip.Nation
+}
+
 func SetOrder(c common.WSContext) (result interface{}, err error) {
-	var base64DecodedId []byte
-	if base64DecodedId, err = base64.URLEncoding.DecodeString(c.Data().GetString("GameId")); err != nil {
-		return
-	}
+	req := OrderRequest{}
+	c.Data().Overwrite(&req)
 	err = c.DB().Transact(func(d *kol.DB) (err error) {
-		game := Game{Id: base64DecodedId}
+		game := Game{Id: req.GameId}
 		if err = d.Get(&game); err != nil {
 			return
 		}
-		var member *Member
-		if member, err = game.Member(d, c.Principal()); err != nil {
+		var members Members
+		if members, err = game.Members(d); err != nil {
+			return
+		}
+		member := members.GetNation(c.Principal(), req.Nation)
+		if member == nil {
+			err = fmt.Errorf("Not member of game")
 			return
 		}
 		var phase *Phase
@@ -134,7 +168,7 @@ func SetOrder(c common.WSContext) (result interface{}, err error) {
 			nationOrders = map[dip.Province][]string{}
 			phase.Orders[member.Nation] = nationOrders
 		}
-		order := c.Data().GetStringSlice("Order")
+		order := req.Order
 		if len(order) == 1 {
 			delete(nationOrders, dip.Province(order[0]))
 		} else {
@@ -156,7 +190,325 @@ func SetOrder(c common.WSContext) (result interface{}, err error) {
 		if err = d.Set(phase); err != nil {
 			return
 		}
+		if member.Autopilot {
+			// The member is back, and has to commit the orders itself
+			member.Autopilot = false
+			member.Committed = false
+			if err = d.Set(member); err != nil {
+				return
+			}
+		}
 		return
 	})
 	return
 }
+
+type VoteRequest struct {
+	GameId  kol.Id
+	Vote    bool
+	Minutes Minutes
+}
+
+func VotePause(c common.WSContext) (result interface{}, err error) {
+	err = castVote(c, func(c common.WSContext, game *Game, member *Member, req VoteRequest) (err error) {
+		if game.Paused {
+			err = fmt.Errorf("%+v is already paused", game)
+			return
+		}
+		member.PauseVote = req.Vote
+		return
+	})
+	return
+}
+
+func VoteResume(c common.WSContext) (result interface{}, err error) {
+	err = castVote(c, func(c common.WSContext, game *Game, member *Member, req VoteRequest) (err error) {
+		if !game.Paused {
+			err = fmt.Errorf("%+v is not paused", game)
+			return
+		}
+		member.ResumeVote = req.Vote
+		return
+	})
+	return
+}
+
+func VoteExtension(c common.WSContext) (result interface{}, err error) {
+	err = castVote(c, func(c common.WSContext, game *Game, member *Member, req VoteRequest) (err error) {
+		if req.Minutes < 0 {
+			err = fmt.Errorf("Can't extend by %v minutes", req.Minutes)
+			return
+		}
+		_, phase, err := game.Phase(c.DB(), 0)
+		if err != nil {
+			return
+		}
+		if phase == nil {
+			err = fmt.Errorf("%+v has no phase to extend", game)
+			return
+		}
+		if max := game.Deadlines[phase.Type]; req.Minutes > max {
+			err = fmt.Errorf("Can't extend a %v phase by more than its deadline of %v minutes", phase.Type, max)
+			return
+		}
+		member.ExtensionVote = req.Minutes
+		return
+	})
+	return
+}
+
+func castVote(c common.WSContext, f func(c common.WSContext, game *Game, member *Member, req VoteRequest) error) (err error) {
+	req := VoteRequest{}
+	c.Data().Overwrite(&req)
+	return c.Transact(func(c common.WSContext) (err error) {
+		game := &Game{Id: req.GameId}
+		if err = c.DB().Get(game); err != nil {
+			return
+		}
+		if game.State != common.GameStateStarted {
+			err = fmt.Errorf("%+v is not started", game)
+			return
+		}
+		members, err := game.Members(c.DB())
+		if err != nil {
+			return
+		}
+		member := members.Get(c.Principal())
+		if member == nil {
+			err = fmt.Errorf("Not member of game")
+			return
+		}
+		if err = f(c, game, member, req); err != nil {
+			return
+		}
+		if err = c.DB().Set(member); err != nil {
+			return
+		}
+		return game.countVotes(c.Diet(), members)
+	})
+}
+
+type StartRequest struct {
+	GameId kol.Id
+}
+
+/*
+StartWithBots lets the owner of a game with BotFill start it before it is full, with bots in the empty seats.
+*/
+func StartWithBots(c common.WSContext) (result interface{}, err error) {
+	req := StartRequest{}
+	c.Data().Overwrite(&req)
+	err = c.Transact(func(c common.WSContext) (err error) {
+		game := &Game{Id: req.GameId}
+		if err = c.DB().Get(game); err != nil {
+			return
+		}
+		if !game.OwnerId.Equals(kol.Id(c.Principal())) {
+			err = fmt.Errorf("Only the owner can start %v", game.Id)
+			return
+		}
+		if game.BotFill == "" {
+			err = fmt.Errorf("%v is not filled with bots", game.Id)
+			return
+		}
+		if err = game.start(c.Diet()); err != nil {
+			return
+		}
+		c.Infof("Started %v with %v bots", game.Id, game.BotFill)
+		return
+	})
+	return
+}
+
+type ReportRequest struct {
+	MessageId kol.Id
+	Reason    string
+}
+
+func ReportMessage(c common.WSContext) (result interface{}, err error) {
+	req := ReportRequest{}
+	c.Data().Overwrite(&req)
+	err = c.Transact(func(c common.WSContext) (err error) {
+		message := &Message{Id: req.MessageId}
+		if err = c.DB().Get(message); err != nil {
+			return
+		}
+		game := &Game{Id: message.GameId}
+		if err = c.DB().Get(game); err != nil {
+			return
+		}
+		var member *Member
+		if member, err = game.Member(c.DB(), c.Principal()); err != nil {
+			return
+		}
+		if member == nil || !(message.Public || message.RecipientIds[member.Id.String()]) {
+			err = fmt.Errorf("Can't report messages you haven't received")
+			return
+		}
+		report := &Report{
+			GameId:     game.Id,
+			MessageId:  message.Id,
+			ReporterId: member.Id,
+			Reason:     req.Reason,
+		}
+		if err = c.DB().Set(report); err != nil {
+			return
+		}
+		c.Infof("%v reported %v: %#v", member.Id, message.Id, req.Reason)
+		return
+	})
+	return
+}
+
+type MuteRequest struct {
+	GameId   kol.Id
+	MemberId kol.Id
+	Vote     bool
+}
+
+func VoteMute(c common.WSContext) (result interface{}, err error) {
+	req := MuteRequest{}
+	c.Data().Overwrite(&req)
+	err = c.Transact(func(c common.WSContext) (err error) {
+		game := &Game{Id: req.GameId}
+		if err = c.DB().Get(game); err != nil {
+			return
+		}
+		members, err := game.Members(c.DB())
+		if err != nil {
+			return
+		}
+		member := members.Get(c.Principal())
+		if member == nil {
+			err = fmt.Errorf("Not member of game")
+			return
+		}
+		var target *Member
+		for index, _ := range members {
+			if members[index].Id.Equals(req.MemberId) {
+				target = &members[index]
+			}
+		}
+		if target == nil || target.Id.Equals(member.Id) {
+			err = fmt.Errorf("Can't vote to mute %v", req.MemberId)
+			return
+		}
+		if member.MuteVotes == nil {
+			member.MuteVotes = map[string]bool{}
+		}
+		if req.Vote {
+			member.MuteVotes[target.Id.String()] = true
+		} else {
+			delete(member.MuteVotes, target.Id.String())
+		}
+		if err = c.DB().Set(member); err != nil {
+			return
+		}
+		return members.UpdateMuted(c.DB(), target)
+	})
+	return
+}
+
+const (
+	defaultMessageLimit = 50
+	maxMessageLimit     = 500
+)
+
+/*
+MessagesRequest asks for messages created before the message with Before as CreatedAt and BeforeId as Id, usually the last message
+of the previous page.
+*/
+type MessagesRequest struct {
+	GameId    kol.Id
+	ChannelId string
+	Query     string
+	Before    time.Time
+	BeforeId  kol.Id
+	Limit     int
+}
+
+func (self *MessagesRequest) page(game *Game, member *Member, spectator *Spectator, messages Messages) (result Messages) {
+	limit := self.Limit
+	if limit < 1 {
+		limit = defaultMessageLimit
+	}
+	if limit > maxMessageLimit {
+		limit = maxMessageLimit
+	}
+	sort.Sort(messages)
+	result = Messages{}
+	for index, _ := range messages {
+		message := &messages[index]
+		if !self.Before.IsZero() && !message.before(self.Before, self.BeforeId) {
+			continue
+		}
+		if !message.VisibleTo(game, member, spectator) || !message.Matches(self.Query) {
+			continue
+		}
+		result = append(result, *message.redact(member))
+		if len(result) == limit {
+			break
+		}
+	}
+	return
+}
+
+func loadMessageViewer(c common.WSContext, gameId kol.Id) (game *Game, member *Member, spectator *Spectator, err error) {
+	game = &Game{Id: gameId}
+	if err = c.DB().Get(game); err != nil {
+		return
+	}
+	if member, err = game.Member(c.DB(), c.Principal()); err != nil {
+		return
+	}
+	if member == nil {
+		if spectator, err = game.Spectator(c.DB(), c.Principal()); err != nil {
+			return
+		}
+	}
+	if game.Private && member == nil {
+		err = fmt.Errorf("Not member of game")
+		return
+	}
+	return
+}
+
+/*
+GetMessages returns a page of the messages in one channel, newest first, created before the provided time.
+*/
+func GetMessages(c common.WSContext) (result interface{}, err error) {
+	req := &MessagesRequest{}
+	c.Data().Overwrite(req)
+	game, member, spectator, err := loadMessageViewer(c, req.GameId)
+	if err != nil {
+		return
+	}
+	messages := Messages{}
+	if err = c.DB().Query().Where(kol.Equals{"ChannelKey", ChannelKey(game.Id, req.ChannelId)}).All(&messages); err != nil {
+		return
+	}
+	result = req.page(game, member, spectator, messages)
+	return
+}
+
+/*
+SearchMessages returns a page of the messages in all channels visible to the caller containing all words in the query, newest first.
+*/
+func SearchMessages(c common.WSContext) (result interface{}, err error) {
+	req := &MessagesRequest{}
+	c.Data().Overwrite(req)
+	if len(strings.Fields(req.Query)) == 0 {
+		err = fmt.Errorf("Empty query")
+		return
+	}
+	game, member, spectator, err := loadMessageViewer(c, req.GameId)
+	if err != nil {
+		return
+	}
+	messages := Messages{}
+	if err = c.DB().Query().Where(kol.Equals{"GameId", game.Id}).All(&messages); err != nil {
+		return
+	}
+	result = req.page(game, member, spectator, messages)
+	return
+}
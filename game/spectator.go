@@ -0,0 +1,35 @@
+package game
+
+import (
+	"time"
+
+	"github.com/zond/kcwraps/kol"
+)
+
+type Spectator struct {
+	Id     kol.Id
+	UserId kol.Id `kol:"index"`
+	GameId kol.Id `kol:"index"`
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+type Spectators []Spectator
+
+func (self *Spectator) Deleted(d *kol.DB) {
+	g := Game{Id: self.GameId}
+	if err := d.Get(&g); err == nil {
+		d.EmitUpdate(&g)
+	} else if err != kol.NotFound {
+		panic(err)
+	}
+}
+
+func (self *Spectator) Created(d *kol.DB) {
+	g := Game{Id: self.GameId}
+	if err := d.Get(&g); err != nil {
+		panic(err)
+	}
+	d.EmitUpdate(&g)
+}
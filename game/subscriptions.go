@@ -26,6 +26,9 @@ type GameState struct {
 	TimeLeft       time.Duration
 	Phase          *Phase
 	Phases         int
+	Spectators     int
+
+	CurrentChatFlags common.ChatFlag
 }
 
 type GameStates []GameState
@@ -59,6 +62,78 @@ func (self SortedGameStates) Swap(i, j int) {
 	self.GameStates[j], self.GameStates[i] = self.GameStates[i], self.GameStates[j]
 }
 
+/*
+memberGameStates returns the states of the games of members, the most urgent first.
+*/
+func memberGameStates(c common.WSContext, members []*Member, op string) (states GameStates, err error) {
+	ep, err := epoch.Get(c.DB())
+	if err != nil {
+		return
+	}
+	states = GameStates{}
+	// Users have many members in hot seat practice games
+	seen := map[string]bool{}
+	for _, member := range members {
+		if seen[member.GameId.String()] {
+			continue
+		}
+		seen[member.GameId.String()] = true
+		if op == gosubs.DeleteType {
+			states = append(states, GameState{
+				Game:    &Game{Id: member.GameId},
+				Members: []MemberState{MemberState{Member: member}},
+			})
+		} else {
+			game := &Game{Id: member.GameId}
+			if err = c.DB().Get(game); err != nil {
+				return
+			}
+			var gameMembers Members
+			if gameMembers, err = game.Members(c.DB()); err != nil {
+				return
+			}
+			var state GameState
+			if state, err = game.ToState(c.DB(), gameMembers, member); err != nil {
+				return
+			}
+			states = append(states, state)
+		}
+	}
+	states = states.SortAndLimit(func(a, b GameState) bool {
+		urgencyA := time.Hour * 24 * 365
+		urgencyB := time.Hour * 24 * 365
+		switch a.State {
+		case common.GameStateStarted:
+			if a.Paused {
+				break
+			}
+			_, phase, err := a.Game.Phase(c.DB(), 0)
+			if err == nil {
+				urgencyA = phase.Deadline - ep
+			}
+		case common.GameStateCreated:
+			urgencyA -= 1
+		}
+		switch b.State {
+		case common.GameStateStarted:
+			if b.Paused {
+				break
+			}
+			_, phase, err := b.Game.Phase(c.DB(), 0)
+			if err == nil {
+				urgencyB = phase.Deadline - ep
+			}
+		case common.GameStateCreated:
+			urgencyB -= 1
+		}
+		if urgencyA != urgencyB {
+			return urgencyA < urgencyB
+		}
+		return a.CreatedAt.Before(b.CreatedAt)
+	}, 1024*16)
+	return
+}
+
 func SubscribeMine(c common.WSContext) error {
 	if c.Principal() == "" {
 		return websocket.JSON.Send(c.Conn(), gosubs.Message{
@@ -71,62 +146,11 @@ func SubscribeMine(c common.WSContext) error {
 	s := c.Pack().New(c.Match()[0])
 	s.Query = s.DB().Query().Where(kol.Equals{"UserId", kol.Id(c.Principal())})
 	s.Call = func(i interface{}, op string) (err error) {
-		members := i.([]*Member)
-		var ep time.Duration
-		ep, err = epoch.Get(c.DB())
-		if err != nil {
+		var states GameStates
+		if states, err = memberGameStates(c, i.([]*Member), op); err != nil {
 			return
 		}
-		states := GameStates{}
-		for _, member := range members {
-			if op == gosubs.DeleteType {
-				states = append(states, GameState{
-					Game:    &Game{Id: member.GameId},
-					Members: []MemberState{MemberState{Member: member}},
-				})
-			} else {
-				game := &Game{Id: member.GameId}
-				if err = s.DB().Get(game); err != nil {
-					return
-				}
-				var gameMembers Members
-				if gameMembers, err = game.Members(c.DB()); err != nil {
-					return
-				}
-				var state GameState
-				if state, err = game.ToState(c.DB(), gameMembers, member); err != nil {
-					return
-				}
-				states = append(states, state)
-			}
-		}
 		if op == gosubs.FetchType || len(states) > 0 {
-			states = states.SortAndLimit(func(a, b GameState) bool {
-				urgencyA := time.Hour * 24 * 365
-				urgencyB := time.Hour * 24 * 365
-				switch a.State {
-				case common.GameStateStarted:
-					_, phase, err := a.Game.Phase(c.DB(), 0)
-					if err == nil {
-						urgencyA = phase.Deadline - ep
-					}
-				case common.GameStateCreated:
-					urgencyA -= 1
-				}
-				switch b.State {
-				case common.GameStateStarted:
-					_, phase, err := b.Game.Phase(c.DB(), 0)
-					if err == nil {
-						urgencyB = phase.Deadline - ep
-					}
-				case common.GameStateCreated:
-					urgencyB -= 1
-				}
-				if urgencyA != urgencyB {
-					return urgencyA < urgencyB
-				}
-				return a.CreatedAt.Before(b.CreatedAt)
-			}, 1024*16)
 			return s.Send(states, op)
 		}
 		return nil
@@ -134,6 +158,25 @@ func SubscribeMine(c common.WSContext) error {
 	return s.Subscribe(&Member{})
 }
 
+/*
+visibleGameState returns the state of game as seen by the caller, unless the game is private and the caller isn't a member.
+*/
+func visibleGameState(c common.WSContext, game *Game) (state GameState, visible bool, err error) {
+	members, err := game.Members(c.DB())
+	if err != nil {
+		return
+	}
+	member := members.Get(c.Principal())
+	if game.Private && member == nil {
+		return
+	}
+	if state, err = game.ToState(c.DB(), members, member); err != nil {
+		return
+	}
+	visible = true
+	return
+}
+
 func SubscribeGame(c common.WSContext) error {
 	base64DecodedId, err := base64.URLEncoding.DecodeString(c.Match()[1])
 	if err != nil {
@@ -141,18 +184,11 @@ func SubscribeGame(c common.WSContext) error {
 	}
 	s := c.Pack().New(c.Match()[0])
 	s.Call = func(i interface{}, op string) error {
-		game := i.(*Game)
-		members, err := game.Members(c.DB())
+		state, visible, err := visibleGameState(c, i.(*Game))
 		if err != nil {
 			return err
 		}
-		member := members.Get(c.Principal())
-		isMember := member != nil
-		if !game.Private || isMember {
-			state, err := game.ToState(c.DB(), members, member)
-			if err != nil {
-				return err
-			}
+		if visible {
 			return s.Send(state, op)
 		} else if op == gosubs.FetchType {
 			return s.Send(GameState{}, op)
@@ -210,32 +246,34 @@ func SubscribeMessages(c common.WSContext) (err error) {
 	if err != nil && err != kol.NotFound {
 		return
 	}
-	memberId := ""
-	if member != nil {
-		memberId = member.Id.String()
+	spectator, err := game.Spectator(c.DB(), c.Principal())
+	if err != nil && err != kol.NotFound {
+		return
 	}
 	s := c.Pack().New(c.Match()[0])
 	s.Query = s.DB().Query().Where(kol.Equals{"GameId", base64DecodedId})
 	s.Call = func(i interface{}, op string) (err error) {
 		messages := i.([]*Message)
 		result := Messages{}
+		current := &Game{Id: base64DecodedId}
+		if err = c.DB().Get(current); err != nil {
+			return
+		}
+		var members Members
 		for _, message := range messages {
 			if message.Public {
-				game := &Game{Id: message.GameId}
-				if err = c.DB().Get(game); err != nil {
-					return
-				}
-				var members Members
-				if members, err = game.Members(c.DB()); err != nil {
-					return
+				if members == nil {
+					if members, err = current.Members(c.DB()); err != nil {
+						return
+					}
 				}
 				message.RecipientIds = map[string]bool{}
 				for _, memb := range members {
 					message.RecipientIds[memb.Id.String()] = true
 				}
 			}
-			if message.Public || message.RecipientIds[memberId] {
-				result = append(result, *message)
+			if message.VisibleTo(current, member, spectator) {
+				result = append(result, *message.redact(member))
 			}
 		}
 		if op == gosubs.FetchType || len(result) > 0 {
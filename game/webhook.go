@@ -0,0 +1,489 @@
+package game
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/zond/diplicity/common"
+	dip "github.com/zond/godip/common"
+	"github.com/zond/kcwraps/kol"
+)
+
+const (
+	WebhookMemberJoined  = "MemberJoined"
+	WebhookGameStarted   = "GameStarted"
+	WebhookPhaseResolved = "PhaseResolved"
+	WebhookPublicMessage = "PublicMessage"
+	WebhookGameEnded     = "GameEnded"
+)
+
+const (
+	WebhookSignatureHeader = "X-Diplicity-Signature"
+	MaxWebhookAttempts     = 10
+	webhookTimeout         = time.Second * 10
+	webhookPoll            = time.Minute
+	webhookMinBackoff      = time.Second * 30
+	webhookMaxBackoff      = time.Hour * 6
+	webhookRetention       = time.Hour * 24 * 7
+)
+
+var webhookWake = make(chan struct{}, 1)
+
+/*
+Webhook is a URL that gets signed JSON payloads POSTed to it when things happen in games.
+
+Webhooks without GameId belong to a user, and get the events of all games the user is member of.
+Webhooks with GameId are registered by the owner of that game, and get the events of that game.
+*/
+type Webhook struct {
+	Id     kol.Id
+	UserId kol.Id `kol:"index"`
+	GameId kol.Id `kol:"index"`
+	URL    string
+	Secret string
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+type Webhooks []Webhook
+
+/*
+WebhookDelivery is a queued, or delivered, payload for a Webhook.
+*/
+type WebhookDelivery struct {
+	Id            kol.Id
+	WebhookId     kol.Id `kol:"index"`
+	Pending       bool   `kol:"index"`
+	Event         string
+	Payload       []byte
+	Attempts      int
+	NextAttemptAt time.Time
+	LastStatus    int
+	LastError     string
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+type WebhookDeliveries []WebhookDelivery
+
+func (self WebhookDeliveries) Len() int {
+	return len(self)
+}
+
+func (self WebhookDeliveries) Less(j, i int) bool {
+	return self[i].CreatedAt.Before(self[j].CreatedAt)
+}
+
+func (self WebhookDeliveries) Swap(i, j int) {
+	self[i], self[j] = self[j], self[i]
+}
+
+/*
+WebhookPayload is what webhooks receive, with event specific Data.
+*/
+type WebhookPayload struct {
+	Event  string
+	GameId kol.Id
+	At     time.Time
+	Data   interface{}
+}
+
+type webhookMemberJoined struct {
+	Members int
+}
+
+type webhookPhase struct {
+	Season      dip.Season
+	Year        int
+	Type        dip.PhaseType
+	Ordinal     int
+	Resolutions map[dip.Province]string
+}
+
+func newWebhookPhase(phase *Phase) webhookPhase {
+	return webhookPhase{
+		Season:      phase.Season,
+		Year:        phase.Year,
+		Type:        phase.Type,
+		Ordinal:     phase.Ordinal,
+		Resolutions: phase.Resolutions,
+	}
+}
+
+type webhookMessage struct {
+	MessageId kol.Id
+	Sender    string
+	Body      string
+}
+
+type webhookGameEnded struct {
+	EndReason common.EndReason
+}
+
+func generateWebhookSecret() (result string, err error) {
+	b := make([]byte, 32)
+	if _, err = rand.Read(b); err != nil {
+		return
+	}
+	result = hex.EncodeToString(b)
+	return
+}
+
+/*
+SignWebhookPayload returns the hex encoded HMAC-SHA256 of payload, sent in the WebhookSignatureHeader.
+*/
+func SignWebhookPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+/*
+webhooksFor returns the webhooks of the owner of game, and of its members.
+*/
+func webhooksFor(d *kol.DB, game *Game) (result Webhooks, err error) {
+	if err = d.Query().Where(kol.Equals{"GameId", game.Id}).All(&result); err != nil {
+		return
+	}
+	members, err := game.Members(d)
+	if err != nil {
+		return
+	}
+	seen := map[string]bool{}
+	for _, member := range members {
+		if member.Bot != "" || seen[member.UserId.String()] {
+			continue
+		}
+		seen[member.UserId.String()] = true
+		hooks := Webhooks{}
+		if err = d.Query().Where(kol.Equals{"UserId", member.UserId}).All(&hooks); err != nil {
+			return
+		}
+		for _, hook := range hooks {
+			if len(hook.GameId) == 0 {
+				result = append(result, hook)
+			}
+		}
+	}
+	return
+}
+
+/*
+fireWebhooks queues deliveries of event to all webhooks interested in game.
+*/
+func fireWebhooks(c common.SkinnyContext, game *Game, event string, data interface{}) (err error) {
+	hooks, err := webhooksFor(c.DB(), game)
+	if err != nil || len(hooks) == 0 {
+		return
+	}
+	payload, err := json.Marshal(WebhookPayload{
+		Event:  event,
+		GameId: game.Id,
+		At:     time.Now(),
+		Data:   data,
+	})
+	if err != nil {
+		return
+	}
+	for _, hook := range hooks {
+		delivery := &WebhookDelivery{
+			WebhookId:     hook.Id,
+			Pending:       true,
+			Event:         event,
+			Payload:       payload,
+			NextAttemptAt: time.Now(),
+		}
+		if err = c.DB().Set(delivery); err != nil {
+			return
+		}
+	}
+	c.BetweenTransactions(func(c common.SkinnyContext) {
+		select {
+		case webhookWake <- struct{}{}:
+		default:
+		}
+	})
+	return
+}
+
+/*
+StartWebhooks delivers queued webhook payloads in the background, retrying failed deliveries with exponential backoff.
+*/
+func StartWebhooks(c common.SkinnyContext) {
+	go func() {
+		for {
+			select {
+			case <-webhookWake:
+			case <-time.After(deliverWebhooks(c)):
+			}
+		}
+	}()
+}
+
+func webhookBackoff(attempts int) (result time.Duration) {
+	result = webhookMinBackoff
+	for i := 1; i < attempts && result < webhookMaxBackoff; i++ {
+		result *= 2
+	}
+	if result > webhookMaxBackoff {
+		result = webhookMaxBackoff
+	}
+	return
+}
+
+var carrierGradeNAT = &net.IPNet{
+	IP:   net.IPv4(100, 64, 0, 0),
+	Mask: net.CIDRMask(10, 32),
+}
+
+/*
+forbiddenWebhookIP returns whether ip is loopback, private, link local or otherwise not on the public internet,
+to not let webhooks probe the server or its network.
+*/
+func forbiddenWebhookIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() || carrierGradeNAT.Contains(ip)
+}
+
+/*
+validateWebhookURL checks that raw is a http or https URL whose host doesn't resolve to a forbidden address.
+*/
+func validateWebhookURL(raw string, lookup func(host string) ([]net.IP, error)) (err error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		err = fmt.Errorf("Webhook URLs must be http or https, not %#v", u.Scheme)
+		return
+	}
+	host := u.Hostname()
+	if host == "" {
+		err = fmt.Errorf("Webhook URL %#v has no host", raw)
+		return
+	}
+	ips := []net.IP{}
+	if ip := net.ParseIP(host); ip != nil {
+		ips = append(ips, ip)
+	} else if ips, err = lookup(host); err != nil {
+		return
+	}
+	for _, ip := range ips {
+		if forbiddenWebhookIP(ip) {
+			err = fmt.Errorf("Webhook URL %#v points to a forbidden address", raw)
+			return
+		}
+	}
+	return
+}
+
+/*
+webhookDialControl refuses connections to forbidden addresses, since the host of a webhook may resolve to something else
+when delivering than when it was created.
+*/
+func webhookDialControl(network, address string, conn syscall.RawConn) (err error) {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return
+	}
+	if ip := net.ParseIP(host); ip == nil || forbiddenWebhookIP(ip) {
+		err = fmt.Errorf("Refusing to deliver webhooks to %v", address)
+	}
+	return
+}
+
+func deliverWebhooks(c common.SkinnyContext) (wait time.Duration) {
+	wait = webhookPoll
+	deliveries := WebhookDeliveries{}
+	if err := c.DB().Query().Where(kol.Equals{"Pending", true}).All(&deliveries); err != nil {
+		c.Errorf("Unable to load webhook deliveries: %v", err)
+		return
+	}
+	client := &http.Client{
+		Timeout: webhookTimeout,
+		Transport: &http.Transport{
+			DialContext: (&net.Dialer{
+				Timeout: webhookTimeout,
+				Control: webhookDialControl,
+			}).DialContext,
+		},
+	}
+	for index, _ := range deliveries {
+		delivery := &deliveries[index]
+		now := time.Now()
+		if delivery.NextAttemptAt.After(now) {
+			if until := delivery.NextAttemptAt.Sub(now); until < wait {
+				wait = until
+			}
+			continue
+		}
+		hook := &Webhook{Id: delivery.WebhookId}
+		if err := c.DB().Get(hook); err != nil {
+			if err == kol.NotFound {
+				if err = c.DB().Del(delivery); err != nil {
+					c.Errorf("Unable to remove %v: %v", delivery.Id, err)
+				}
+			} else {
+				c.Errorf("Unable to load %v: %v", delivery.WebhookId, err)
+			}
+			continue
+		}
+		delivery.Attempts++
+		delivery.LastStatus, delivery.LastError = 0, ""
+		req, err := http.NewRequest("POST", hook.URL, bytes.NewReader(delivery.Payload))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+			req.Header.Set(WebhookSignatureHeader, SignWebhookPayload(hook.Secret, delivery.Payload))
+			var resp *http.Response
+			if resp, err = client.Do(req); err == nil {
+				resp.Body.Close()
+				delivery.LastStatus = resp.StatusCode
+				if resp.StatusCode < 200 || resp.StatusCode > 299 {
+					err = fmt.Errorf("%v returned %v", hook.URL, resp.Status)
+				}
+			}
+		}
+		if err != nil {
+			delivery.LastError = err.Error()
+			if delivery.Attempts >= MaxWebhookAttempts {
+				c.Errorf("Giving up delivering %v to %v after %v attempts: %v", delivery.Id, hook.URL, delivery.Attempts, err)
+				delivery.Pending = false
+			} else {
+				delivery.NextAttemptAt = now.Add(webhookBackoff(delivery.Attempts))
+				if until := delivery.NextAttemptAt.Sub(now); until < wait {
+					wait = until
+				}
+			}
+		} else {
+			delivery.Pending = false
+		}
+		if err := c.DB().Set(delivery); err != nil {
+			c.Errorf("Unable to update %v: %v", delivery.Id, err)
+		}
+	}
+	pruneWebhookDeliveries(c)
+	return
+}
+
+func pruneWebhookDeliveries(c common.SkinnyContext) {
+	deliveries := WebhookDeliveries{}
+	if err := c.DB().Query().Where(kol.Equals{"Pending", false}).All(&deliveries); err != nil {
+		c.Errorf("Unable to load webhook deliveries: %v", err)
+		return
+	}
+	for index, _ := range deliveries {
+		if time.Now().Sub(deliveries[index].UpdatedAt) > webhookRetention {
+			if err := c.DB().Del(&deliveries[index]); err != nil {
+				c.Errorf("Unable to remove %v: %v", deliveries[index].Id, err)
+			}
+		}
+	}
+}
+
+type WebhookRequest struct {
+	Id     kol.Id
+	GameId kol.Id
+	URL    string
+}
+
+/*
+CreateWebhook registers a webhook for all games of the caller, or for a single game owned by the caller.
+*/
+func CreateWebhook(c common.WSContext) (result interface{}, err error) {
+	req := WebhookRequest{}
+	c.Data().Overwrite(&req)
+	if req.URL == "" {
+		err = fmt.Errorf("Missing URL")
+		return
+	}
+	if err = validateWebhookURL(req.URL, net.LookupIP); err != nil {
+		return
+	}
+	hook := &Webhook{
+		UserId: kol.Id(c.Principal()),
+		GameId: req.GameId,
+		URL:    req.URL,
+	}
+	if hook.Secret, err = generateWebhookSecret(); err != nil {
+		return
+	}
+	err = c.Transact(func(c common.WSContext) (err error) {
+		if len(hook.GameId) > 0 {
+			game := &Game{Id: hook.GameId}
+			if err = c.DB().Get(game); err != nil {
+				return
+			}
+			if !game.OwnerId.Equals(kol.Id(c.Principal())) {
+				err = fmt.Errorf("Only the owner of %v can register webhooks for it", game.Id)
+				return
+			}
+		}
+		return c.DB().Set(hook)
+	})
+	result = hook
+	return
+}
+
+func loadOwnWebhook(c common.WSContext, id kol.Id) (result *Webhook, err error) {
+	result = &Webhook{Id: id}
+	if err = c.DB().Get(result); err != nil {
+		return
+	}
+	if !result.UserId.Equals(kol.Id(c.Principal())) {
+		err = fmt.Errorf("%v is not your webhook", id)
+	}
+	return
+}
+
+func DeleteWebhook(c common.WSContext) (result interface{}, err error) {
+	req := WebhookRequest{}
+	c.Data().Overwrite(&req)
+	err = c.Transact(func(c common.WSContext) (err error) {
+		hook, err := loadOwnWebhook(c, req.Id)
+		if err != nil {
+			return
+		}
+		return c.DB().Del(hook)
+	})
+	return
+}
+
+func GetWebhooks(c common.WSContext) (result interface{}, err error) {
+	hooks := Webhooks{}
+	if err = c.DB().Query().Where(kol.Equals{"UserId", kol.Id(c.Principal())}).All(&hooks); err != nil {
+		return
+	}
+	result = hooks
+	return
+}
+
+/*
+GetWebhookDeliveries returns the pending and recent deliveries of a webhook of the caller, newest first.
+*/
+func GetWebhookDeliveries(c common.WSContext) (result interface{}, err error) {
+	req := WebhookRequest{}
+	c.Data().Overwrite(&req)
+	hook, err := loadOwnWebhook(c, req.Id)
+	if err != nil {
+		return
+	}
+	deliveries := WebhookDeliveries{}
+	if err = c.DB().Query().Where(kol.Equals{"WebhookId", hook.Id}).All(&deliveries); err != nil {
+		return
+	}
+	sort.Sort(deliveries)
+	result = deliveries
+	return
+}
@@ -21,6 +21,9 @@ window.applicationCache.addEventListener('updateready', function(event) {
 
 window.session = {};
 
+// The nation played in each hot seat practice game, by game id
+window.session.playingNations = {};
+
 window.session.online = false;
 window.session.updateOnlineTag = function() {
 	if (window.session.online) {
@@ -73,14 +73,26 @@ window.GameState = Backbone.Model.extend({
 		if ((this.get(typ + 'Consequences') & {{.Consequence "Surrender"}}) == {{.Consequence "Surrender"}}) {
 		  cons.push('{{.I "Surrender"}}');
 		}
+		if ((this.get(typ + 'Consequences') & {{.Consequence "Autopilot"}}) == {{.Consequence "Autopilot"}}) {
+		  cons.push('{{.I "Autopilot"}}');
+		}
 		return cons.join(", ");
 	},
 
 	me: function() {
+		var mine = this.mine();
+		var playing = window.session.playingNations[this.get('Id')];
+		return _.find(mine, function(member) {
+		  return member.Nation == playing;
+		}) || mine[0] || null;
+	},
+
+	// All members of the user, which are more than one for the owner of a hot seat practice game
+	mine: function() {
 	  if (window.session.user.get('Email') == null || window.session.user.get('Email') == "") {
-		  return null;
+		  return [];
 		}
-	  return _.find(this.get('Members'), function(member) {
+	  return _.filter(this.get('Members'), function(member) {
 		  return member.User.Email == window.session.user.get('Email');
 		});
 	},
@@ -184,6 +196,9 @@ window.GameState = Backbone.Model.extend({
 	},
 
 	currentChatFlags: function() {
+	  if (this.get('CurrentChatFlags') != null) {
+		  return this.get('CurrentChatFlags');
+		}
 	  return this.get('ChatFlags')[this.currentPhaseType()];
 	},
 
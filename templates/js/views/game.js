@@ -144,6 +144,7 @@ window.GameView = BaseView.extend({
 								RPC('SetOrder', {
 									GameId: that.model.get('Id'),
 									Order: [provToCancel],
+									Nation: that.model.me().Nation,
 								}, function(error) {
 									if (error != null && error != '') {
 										logError('While setting order', [provToCancel], error);
@@ -203,6 +204,7 @@ window.GameView = BaseView.extend({
 			RPC('SetOrder', {
 				GameId: that.model.get('Id'),
 				Order: decision,
+				Nation: that.model.me().Nation,
 			}, function(error) {
 			  if (error != null && error != '') {
 					logError('While setting order', decision, error);
@@ -8,6 +8,7 @@ window.GameControlsView = BaseView.extend({
     "click .view-orders": "viewOrders",
     "click .view-results": "viewResults",
 		"click .commit-phase": "commitPhase",
+		"change .playing-nation": "changePlayingNation",
 		"click .uncommit-phase": "uncommitPhase",
 		"click .previous-phase": "phaseBack",
 		"click .next-phase": "phaseForward",
@@ -64,6 +65,7 @@ window.GameControlsView = BaseView.extend({
 		if (me != null && !me.NoOrders) {
 			RPC('Commit', {
 				PhaseId: that.model.get('Phase').Id,
+				Nation: me.Nation,
 			}, function(error) {
 				if (error != null && error != '') {
 					logError('While committing', error);
@@ -80,6 +82,7 @@ window.GameControlsView = BaseView.extend({
 		if (me != null && !me.NoOrders) {
 			RPC('Uncommit', {
 				PhaseId: that.model.get('Phase').Id,
+				Nation: me.Nation,
 			}, function(error) {
 				if (error != null && error != '') {
 					logError('While uncommitting', error);
@@ -88,6 +91,12 @@ window.GameControlsView = BaseView.extend({
 		}
 	},
 
+	changePlayingNation: function(ev) {
+		window.session.playingNations[this.model.get('Id')] = $(ev.target).val();
+		this.update();
+		this.gameView.resetDecision();
+	},
+
 	viewMap: function(ev) {
 		var that = this;
 	  if (that.currentView != null) {
@@ -186,6 +195,15 @@ window.GameControlsView = BaseView.extend({
 			that.$('.view-orders').css('visibility', 'visible');
 			that.$('.view-results').css('visibility', 'visible');
 			var me = that.model.me();
+			var mine = that.model.mine();
+			if (mine.length > 1) {
+				var select = that.$('.playing-nation');
+				select.empty();
+				_.each(mine, function(member) {
+					select.append($('<option>').attr('value', member.Nation).text({{.I "nations" }}[member.Nation]));
+				});
+				select.val(me.Nation).show();
+			}
 			if (me != null) {
 				that.$('.commit-button').css('visibility', 'visible');
 				if (me.Committed) {
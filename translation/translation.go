@@ -25,6 +25,8 @@ var en = map[string]string{
 	"Reliability hit":       "Reliability hit",
 	"No wait":               "No wait",
 	"Surrender":             "Surrender",
+	"Autopilot":             "Autopilot",
+	"Nation":                "Nation",
 	"Resolve":               "Resolve",
 	"Not committing":        "Not committing",
 	"Shorten URL":           "Shorten URL",
@@ -53,6 +55,14 @@ var en = map[string]string{
 	"To see this in context: http://%v/games/%v":                     "To see this in context: http://%v/games/%v",
 	"To see this message in context: http://%v/games/%v/messages/%v": "To see this message in context: http://%v/games/%v/messages/%v",
 	"To unsubscribe: http://%v/unsubscribe/%v":                       "To unsubscribe: http://%v/unsubscribe/%v",
+	"To see your games: http://%v/":                                  "To see your games: http://%v/",
+	"See this in context":                                            "See this in context",
+	"Unsubscribe":                                                    "Unsubscribe",
+	"Diplicity digest":                                               "Diplicity digest",
+	"The game has been rolled back to %v":                             "The game has been rolled back to %v",
+	"Reason: %v":                                                     "Reason: %v",
+	"The game was cancelled":                                         "The game was cancelled",
+	"Only %v of the %v players needed joined the game before it had to start.": "Only %v of the %v players needed joined the game before it had to start.",
 	"Ranking":           "Ranking",
 	"Members":           "Members",
 	"Toggle navigation": "Toggle navigation",
@@ -66,6 +76,7 @@ var en = map[string]string{
 	"Group press":       "Group press",
 	"Leave":             "Leave",
 	"Conference press":  "Conference press",
+	"Grey press":        "Grey press",
 	"Conference":        "Conference",
 	"1 minute":          "1 minute",
 	"5 minutes":         "5 minutes",
@@ -166,6 +177,16 @@ var languages = map[string]map[string]string{
 	"en": en,
 }
 
+/*
+GetLanguage returns language if there are translations for it, and the default language otherwise.
+*/
+func GetLanguage(language string) string {
+	if _, ok := languages[language]; ok {
+		return language
+	}
+	return "en"
+}
+
 func GetTranslations(language string) (result map[string]string) {
 	result, ok := languages[language]
 	if !ok {
@@ -0,0 +1,145 @@
+package user
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/zond/diplicity/common"
+	"github.com/zond/kcwraps/kol"
+)
+
+/*
+APIToken is a named, revocable personal token that bots and scripts authenticate with.
+
+Only the digest of the token is stored, the token itself is only shown when it is created.
+*/
+type APIToken struct {
+	Id     kol.Id
+	UserId kol.Id `kol:"index"`
+	Name   string
+	Scopes []string
+	Digest string `kol:"index"`
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+type APITokens []APIToken
+
+/*
+CreatedAPIToken is an APIToken along with the token itself, returned only by CreateAPIToken.
+*/
+type CreatedAPIToken struct {
+	*APIToken
+	Token string
+}
+
+func apiTokenDigest(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+/*
+ResolveAPIToken is the common.APITokenResolver for APITokens.
+*/
+func ResolveAPIToken(d *kol.DB, token string) (principal string, scopes []string, err error) {
+	apiToken := &APIToken{}
+	found, err := d.Query().Where(kol.Equals{"Digest", apiTokenDigest(token)}).First(apiToken)
+	if err != nil {
+		return
+	}
+	if !found {
+		err = fmt.Errorf("Unknown API token")
+		return
+	}
+	principal, scopes = string(apiToken.UserId), apiToken.Scopes
+	return
+}
+
+type APITokenRequest struct {
+	Id     kol.Id
+	Name   string
+	Scopes []string
+}
+
+/*
+CreateAPIToken mints a new API token for the caller with the requested scopes, and returns it.
+*/
+func CreateAPIToken(c common.WSContext) (result interface{}, err error) {
+	req := APITokenRequest{}
+	c.Data().Overwrite(&req)
+	if req.Name == "" {
+		err = fmt.Errorf("Missing name")
+		return
+	}
+	for _, scope := range req.Scopes {
+		known := false
+		for _, apiScope := range common.APIScopes {
+			known = known || scope == apiScope
+		}
+		if !known {
+			err = fmt.Errorf("Unknown scope %#v", scope)
+			return
+		}
+	}
+	b := make([]byte, 24)
+	if _, err = rand.Read(b); err != nil {
+		return
+	}
+	token := common.APITokenPrefix + base64.URLEncoding.EncodeToString(b)
+	apiToken := &APIToken{
+		UserId: kol.Id(c.Principal()),
+		Name:   req.Name,
+		Scopes: req.Scopes,
+		Digest: apiTokenDigest(token),
+	}
+	if err = c.DB().Set(apiToken); err != nil {
+		return
+	}
+	result = CreatedAPIToken{
+		APIToken: apiToken,
+		Token:    token,
+	}
+	return
+}
+
+/*
+DeleteAPIToken revokes an API token of the caller.
+*/
+func DeleteAPIToken(c common.WSContext) error {
+	req := APITokenRequest{}
+	c.Data().Overwrite(&req)
+	return c.Transact(func(c common.WSContext) (err error) {
+		apiToken := &APIToken{Id: req.Id}
+		if err = c.DB().Get(apiToken); err != nil {
+			return
+		}
+		if string(apiToken.UserId) != c.Principal() {
+			err = fmt.Errorf("Unauthorized")
+			return
+		}
+		return c.DB().Del(apiToken)
+	})
+}
+
+func GetAPITokens(c common.WSContext) (result interface{}, err error) {
+	apiTokens := APITokens{}
+	if err = c.DB().Query().Where(kol.Equals{"UserId", kol.Id(c.Principal())}).All(&apiTokens); err != nil {
+		return
+	}
+	result = apiTokens
+	return
+}
+
+func SubscribeAPITokens(c common.WSContext) error {
+	s := c.Pack().New(c.Match()[0])
+	s.Query = s.DB().Query().Where(kol.Equals{"UserId", kol.Id(c.Principal())})
+	s.Call = func(i interface{}, op string) error {
+		return s.Send(i, op)
+	}
+	return s.Subscribe(&APIToken{})
+}
@@ -9,6 +9,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/zond/diplicity/audit"
 	"github.com/zond/diplicity/common"
 	"github.com/zond/goauth2"
 	"github.com/zond/kcwraps/kol"
@@ -16,6 +17,9 @@ import (
 )
 
 func AdminSetRank1(c *common.HTTPContext) (err error) {
+	defer func() {
+		audit.Record(c, common.Admin, "SetRank1", "", nil, err)
+	}()
 	users := Users{}
 	if err = c.DB().Query().All(&users); err != nil {
 		return
@@ -32,6 +36,8 @@ func AdminSetRank1(c *common.HTTPContext) (err error) {
 }
 
 func AdminBecome(c *common.HTTPContext) (err error) {
+	actor, _ := c.Session().Values[common.SessionEmail].(string)
+	audit.Record(c, actor, "Become", c.Req().FormValue("become"), nil, nil)
 	c.Session().Values[common.SessionEmail] = c.Req().FormValue("become")
 	c.Close()
 	c.Resp().Header().Set("Location", "/")
@@ -42,6 +48,11 @@ func AdminBecome(c *common.HTTPContext) (err error) {
 
 func AdminCreateUser(c *common.HTTPContext) (err error) {
 	user := &User{}
+	defer func() {
+		audit.Record(c, common.Admin, "CreateUser", user.Id.String(), map[string]string{
+			"Email": user.Email,
+		}, err)
+	}()
 	if err = json.NewDecoder(c.Req().Body).Decode(user); err != nil {
 		return
 	}
@@ -11,8 +11,17 @@ import (
 	"github.com/zond/wsubs/gosubs"
 )
 
+const (
+	MaxVacationDaysPerYear = 30
+)
+
 type Users []User
 
+type Vacation struct {
+	Start time.Time
+	End   time.Time
+}
+
 type User struct {
 	Id                   kol.Id
 	Email                string
@@ -24,6 +33,11 @@ type User struct {
 	Ranking              float64
 	Language             string
 	DiplicityHost        string
+	Vacations            []Vacation
+	Digest               bool `kol:"index"`
+	DigestMinutes        int
+	LastDigestAt         time.Time
+	Bot                  bool
 
 	LastLoginAt time.Time
 	CreatedAt   time.Time
@@ -49,6 +63,67 @@ func (self *User) Reliability() float64 {
 	return float64(self.HeldDeadlines+1) / float64(self.MissedDeadlines+1)
 }
 
+func (self *User) OnVacation(at time.Time) *Vacation {
+	for index, _ := range self.Vacations {
+		if !at.Before(self.Vacations[index].Start) && at.Before(self.Vacations[index].End) {
+			return &self.Vacations[index]
+		}
+	}
+	return nil
+}
+
+/*
+SetVacations replaces the planned vacations of the user, keeping the ones already started
+so that nobody can reset their yearly allowance by removing old vacations.
+
+Started vacations can be ended early by including them with an earlier End, but not before now.
+*/
+func (self *User) SetVacations(vacations []Vacation) (err error) {
+	now := time.Now()
+	result := []Vacation{}
+	for _, vacation := range self.Vacations {
+		if vacation.Start.Before(now) {
+			for _, changed := range vacations {
+				if vacation.End.After(now) && changed.Start.Equal(vacation.Start) && changed.End.Before(vacation.End) {
+					vacation.End = changed.End
+					if vacation.End.Before(now) {
+						vacation.End = now
+					}
+				}
+			}
+			result = append(result, vacation)
+		}
+	}
+	for _, vacation := range vacations {
+		if !vacation.Start.Before(now) {
+			if !vacation.End.After(vacation.Start) {
+				err = fmt.Errorf("Vacation %+v ends before it starts", vacation)
+				return
+			}
+			result = append(result, vacation)
+		}
+	}
+	perYear := map[int]time.Duration{}
+	for _, vacation := range result {
+		for at := vacation.Start; at.Before(vacation.End); {
+			next := time.Date(at.Year()+1, 1, 1, 0, 0, 0, 0, at.Location())
+			if next.After(vacation.End) {
+				next = vacation.End
+			}
+			perYear[at.Year()] += next.Sub(at)
+			at = next
+		}
+	}
+	for year, duration := range perYear {
+		if duration > time.Hour*24*MaxVacationDaysPerYear {
+			err = fmt.Errorf("Only %v vacation days allowed per year, %v has %v", MaxVacationDaysPerYear, year, duration)
+			return
+		}
+	}
+	self.Vacations = result
+	return
+}
+
 func (self *User) Blacklistings(d *kol.DB) (result map[string]bool, err error) {
 	result = map[string]bool{}
 	var blacklistings []Blacklisting
@@ -83,6 +158,18 @@ func SubscribeEmail(c common.WSContext) error {
 	return s.Subscribe(&User{Id: kol.Id(c.Principal())})
 }
 
+/*
+Get returns the user of the caller, like the /user subscription.
+*/
+func Get(c common.WSContext) (result interface{}, err error) {
+	user := &User{Id: kol.Id(c.Principal())}
+	if err = c.DB().Get(user); err != nil {
+		return
+	}
+	result = user
+	return
+}
+
 func Update(c common.WSContext) (err error) {
 	var user User
 	c.Data().Overwrite(&user)
@@ -97,6 +184,18 @@ func Update(c common.WSContext) (err error) {
 	current.Nickname = user.Nickname
 	current.MessageEmailDisabled = user.MessageEmailDisabled
 	current.PhaseEmailDisabled = user.PhaseEmailDisabled
+	// Leaving out the vacations, like partial API updates do, keeps them
+	if data, ok := c.Data().Data.(map[string]interface{}); !ok || data["Vacations"] != nil {
+		if err = current.SetVacations(user.Vacations); err != nil {
+			return
+		}
+	}
+	if user.DigestMinutes < 0 {
+		err = fmt.Errorf("Illegal digest interval %v", user.DigestMinutes)
+		return
+	}
+	current.DigestMinutes = user.DigestMinutes
+	current.Digest = current.DigestMinutes > 0
 	err = c.DB().Set(current)
 	return
 }